@@ -1,12 +1,19 @@
 package goconfig
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,6 +21,10 @@ const (
 	DEFAULT_SECTION = "DEFAULT"
 	// Maximum allowed depth when recursively substituing variable names.
 	_DEPTH_VALUES = 200
+	// Prefix of value which refers to contents of a file.
+	FILE_REF_PREFIX = "@file:"
+	// Maximum allowed depth of nested !include directives.
+	_DEPTH_INCLUDES = 10
 )
 
 type ParseError int
@@ -23,14 +34,30 @@ const (
 	ERR_KEY_NOT_FOUND
 	ERR_BLANK_SECTION_NAME
 	ERR_COULD_NOT_PARSE
+	ERR_DUPLICATE_KEY
+	ERR_INVALID_NAME
+	ERR_INCLUDE
 )
 
 var LineBreak = "\n"
 var cf *ConfigFile
 
+var (
+	// ErrSectionNotFound matches errors of section not found with errors.Is.
+	ErrSectionNotFound = errors.New("section not found")
+	// ErrKeyNotFound matches errors of key not found with errors.Is.
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// errNoConfig occurs when get value from nil configuration.
+var errNoConfig = errors.New("no configuration loaded")
+
 // Variable regexp pattern: %(variable)s
 var varPattern = regexp.MustCompile(`%\(([^\)]+)\)s`)
 
+// Environment variable regexp pattern: ${NAME}
+var envPattern = regexp.MustCompile(`\$\{([^\}]+)\}`)
+
 // getError occurs when get value in configuration file with invalid parameter.
 type getError struct {
 	Reason ParseError
@@ -48,32 +75,154 @@ func (err getError) Error() string {
 	return "invalid get error"
 }
 
+// Is reports whether target is the sentinel error of err's reason,
+// so errors.Is(err, ErrKeyNotFound) works with errors returned by getters.
+func (err getError) Is(target error) bool {
+	switch err.Reason {
+	case ERR_SECTION_NOT_FOUND:
+		return target == ErrSectionNotFound
+	case ERR_KEY_NOT_FOUND:
+		return target == ErrKeyNotFound
+	}
+	return false
+}
+
 func init() {
 	if runtime.GOOS == "windows" {
 		LineBreak = "\r\n"
 	}
-	var err error
+}
 
-	cf, err = LoadConfigFile("conf/app.conf")
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	fmt.Println(cf)
+// SetDefault sets the configuration used by package-level functions
+// such as Value and MustInt.
+func SetDefault(c *ConfigFile) {
+	cf = c
 }
 
 // A ConfigFile represents a INI formar configuration file.
 type ConfigFile struct {
-	lock      sync.RWMutex                 // Go map is not safe.
-	fileNames []string                     // Support mutil-files.
-	data      map[string]map[string]string // Section -> key : value
+	lock         sync.RWMutex                 // Go map is not safe.
+	sectionLocks map[string]*sync.RWMutex     // Section -> lock in sharded mode.
+	fileNames    []string                     // Support mutil-files.
+	loadedFiles  []string                     // Files actually loaded.
+	data         map[string]map[string]string // Section -> key : value
 
 	// Lists can keep sections and keys in order.
 	sectionList []string            // Section name list.
 	keyList     map[string][]string // Section -> Key name list
 
-	sectionComments map[string]string            // Sections comments.
-	keyComments     map[string]map[string]string // Keys comments.
-	BlockMode       bool                         // Indicates whether use lock or not.
+	sectionComments map[string]string              // Sections comments.
+	sectionParents  map[string]string              // Section -> inherited section.
+	keyComments     map[string]map[string]string   // Keys comments.
+	keyTypes        map[string]map[string]string   // Keys declared types.
+	keyQuotes       map[string]map[string]string   // Keys original value quotes.
+	keyValues       map[string]map[string][]string // Keys every occurrence, see MultiValues.
+	footerComments  string                         // Comments at the end of file.
+	BlockMode       bool                           // Indicates whether use lock or not.
+
+	// LenientQuotes makes a value whose opening quote is never closed
+	// on the same line be read as a literal instead of failing to parse.
+	LenientQuotes bool
+
+	// DefaultOverrides reverses the usual lookup order so that keys in
+	// DEFAULT section take priority over the same keys in other sections,
+	// which turns DEFAULT into a layer of global overrides.
+	DefaultOverrides bool
+
+	// TypeAnnotations enables parsing of declared types in key names,
+	// e.g. "timeout<duration> = 30s" stores key "timeout" with type "duration".
+	TypeAnnotations bool
+
+	// KeyValueSpacing controls spaces around delimiter when saving.
+	KeyValueSpacing KeyValueSpacing
+
+	// CommentPrefixes are the prefixes of comment lines, ";" and "#" if empty.
+	// The first one is prepended to comments set without any of them.
+	CommentPrefixes []string
+
+	// StrictDuplicates makes reading fail on a key which is already set
+	// in the same section of the same file, instead of overwriting it.
+	StrictDuplicates bool
+
+	// InlineComment makes text after a comment prefix which follows a space
+	// in unquoted value, or after the closing quote, the comments of the key,
+	// e.g. "port = 8080 ; http port" sets "8080" with comments "; http port".
+	// Double quotes also wrap value with it, e.g. `name = "a ; b"`.
+	InlineComment bool
+
+	// LineContinuation makes a line ending with backslash continue on
+	// the next line, the backslash is removed and lines are joined with
+	// nothing between, so spaces before the backslash are kept but
+	// indentation is not. Comment lines never continue.
+	LineContinuation bool
+
+	// Delimiters are the characters which separate key and value,
+	// the first one in a line is used. It is "=:" if empty.
+	// The first delimiter is written when saving, e.g. " " for
+	// "key value" files.
+	Delimiters string
+
+	// ExpandEnv enables expansion of every ${NAME} in values
+	// with environment variable NAME, undefined variables expand to empty.
+	// It is part of variable substitution, so %(name)s in environment
+	// variables are substituted too, up to _DEPTH_VALUES iterations.
+	ExpandEnv bool
+	// StrictVars makes undefined variables an error instead of empty,
+	// both %(name)s and ${NAME}.
+	StrictVars bool
+	// Resolver looks up ${NAME} instead of environment variables if not nil,
+	// its error is returned by getters as is.
+	Resolver func(ctx context.Context, name string) (string, error)
+
+	// IndentNesting makes keys indented more than a previous key with empty
+	// value be in the sub-section named by that key, which is not a key
+	// itself then, e.g. "db =" followed by "  host = localhost" in [app]
+	// sets key host of section "app.db". Deeper indentation nests further.
+	// Saving writes such sub-sections as usual "[app.db]" sections.
+	IndentNesting bool
+
+	// MultiValues keeps every occurrence of a key repeated in a section
+	// when reading, e.g. "server = a" and "server = b", instead of only
+	// the last one. GetValue returns the last occurrence, GetValues returns
+	// all of them and saving writes each one. SetValue replaces them all.
+	MultiValues bool
+
+	// SectionArrays enables "[[name]]" headers, every one of them starts
+	// a new section "name[0]", "name[1]" and so on, see GetSectionArray.
+	SectionArrays bool
+
+	// ShardedLocks gives every section its own lock, so overwriting
+	// existing keys in different sections does not block each other.
+	// Adding keys or sections still locks the whole configuration,
+	// and readers have to lock every section, which makes reads slower.
+	// It only takes effect with BlockMode and must be set before concurrent use.
+	ShardedLocks bool
+
+	// CaseInsensitive makes section and key names match regardless of case,
+	// e.g. [Database] and [database] are the same section. The first-seen
+	// spelling of a name is kept and used when saving.
+	CaseInsensitive bool
+
+	// StrictNames rejects section and key names which can't be saved
+	// and read back correctly, both when reading and in SetValue.
+	StrictNames bool
+
+	// FileRefs makes value "@file:path" be replaced by contents of file path
+	// without trailing line break, e.g. to keep secrets in separate files.
+	// Variables in the value are substituted before reading the file.
+	FileRefs     bool
+	fileRefs     map[string]string // File path -> contents cache.
+	fileRefsLock sync.Mutex
+
+	// OnReloadError is called with the error of Reload started by AutoReload.
+	OnReloadError func(err error)
+	reloadHooks   []func(before, after *ConfigFile) // Registered by OnReload.
+
+	metricsHook func(section, key string, found bool, dur time.Duration) // Set by SetMetricsHook.
+
+	fallbackSection string // Set by SetFallbackSection.
+
+	envPrefix, envSeparator string // Set by SetEnvOverride.
 }
 
 // Value return string type value.
@@ -84,88 +233,85 @@ func Value(section, key string) (string, error) {
 
 // Bool returns bool type value.
 func Bool(section, key string) (bool, error) {
-	value, err := cf.getValue(section, key)
-	if err != nil {
-		return false, err
-	}
-	return strconv.ParseBool(value)
+	return cf.Bool(section, key)
 }
 
 // Float64 returns float64 type value.
 func Float64(section, key string) (float64, error) {
-	value, err := cf.getValue(section, key)
-	if err != nil {
-		return 0.0, err
-	}
-	return strconv.ParseFloat(value, 64)
+	return cf.Float64(section, key)
 }
 
 // Int returns int type value.
 func Int(section, key string) (int, error) {
-	value, err := cf.getValue(section, key)
-	if err != nil {
-		return 0, err
-	}
-	return strconv.Atoi(value)
+	return cf.Int(section, key)
 }
 
 // Int64 returns int64 type value.
 func Int64(section, key string) (int64, error) {
-	value, err := cf.getValue(section, key)
-	if err != nil {
-		return 0, err
-	}
-	return strconv.ParseInt(value, 10, 64)
+	return cf.Int64(section, key)
+}
+
+// Uint returns uint type value.
+func Uint(section, key string) (uint, error) {
+	return cf.Uint(section, key)
+}
+
+// Uint64 returns uint64 type value.
+func Uint64(section, key string) (uint64, error) {
+	return cf.Uint64(section, key)
+}
+
+// Duration returns time.Duration type value.
+func Duration(section, key string) (time.Duration, error) {
+	return cf.Duration(section, key)
 }
 
 // MustValue always returns value without error.
 // It returns empty string if error occurs, or the default value if given.
 func MustValue(section, key string, defaultVal ...string) string {
-	val, err := cf.getValue(section, key)
-	if len(defaultVal) > 0 && (err != nil || len(val) == 0) {
-		return defaultVal[0]
-	}
-	return val
+	return cf.MustValue(section, key, defaultVal...)
 }
 
 // MustBool always returns value without error,
 // it returns false if error occurs.
 func MustBool(section, key string, defaultVal ...bool) bool {
-	val, err := Bool(section, key)
-	if len(defaultVal) > 0 && err != nil {
-		return defaultVal[0]
-	}
-	return val
+	return cf.MustBool(section, key, defaultVal...)
 }
 
 // MustFloat64 always returns value without error,
 // it returns 0.0 if error occurs.
 func MustFloat64(section, key string, defaultVal ...float64) float64 {
-	value, err := Float64(section, key)
-	if len(defaultVal) > 0 && err != nil {
-		return defaultVal[0]
-	}
-	return value
+	return cf.MustFloat64(section, key, defaultVal...)
 }
 
 // MustInt always returns value without error,
 // it returns 0 if error occurs.
 func MustInt(section, key string, defaultVal ...int) int {
-	value, err := Int(section, key)
-	if len(defaultVal) > 0 && err != nil {
-		return defaultVal[0]
-	}
-	return value
+	return cf.MustInt(section, key, defaultVal...)
 }
 
 // MustInt64 always returns value without error,
 // it returns 0 if error occurs.
 func MustInt64(section, key string, defaultVal ...int64) int64 {
-	value, err := Int64(section, key)
-	if len(defaultVal) > 0 && err != nil {
-		return defaultVal[0]
-	}
-	return value
+	return cf.MustInt64(section, key, defaultVal...)
+}
+
+// MustUint always returns value without error,
+// it returns 0 if error occurs.
+func MustUint(section, key string, defaultVal ...uint) uint {
+	return cf.MustUint(section, key, defaultVal...)
+}
+
+// MustUint64 always returns value without error,
+// it returns 0 if error occurs.
+func MustUint64(section, key string, defaultVal ...uint64) uint64 {
+	return cf.MustUint64(section, key, defaultVal...)
+}
+
+// MustDuration always returns value without error,
+// it returns 0 if error occurs.
+func MustDuration(section, key string, defaultVal ...time.Duration) time.Duration {
+	return cf.MustDuration(section, key, defaultVal...)
 }
 
 // newConfigFile creates an empty configuration representation.
@@ -175,21 +321,69 @@ func newConfigFile(fileNames []string) *ConfigFile {
 	c.data = make(map[string]map[string]string)
 	c.keyList = make(map[string][]string)
 	c.sectionComments = make(map[string]string)
+	c.sectionParents = make(map[string]string)
 	c.keyComments = make(map[string]map[string]string)
+	c.keyTypes = make(map[string]map[string]string)
+	c.keyQuotes = make(map[string]map[string]string)
 	c.BlockMode = true
+	c.fallbackSection = DEFAULT_SECTION
+	return c
+}
+
+// NewConfigFile creates an empty configuration with BlockMode set to blockMode.
+// Without BlockMode no method locks, which saves the locking overhead for
+// configurations that are only read after loading, e.g. by AppendFiles,
+// but then c must not be changed while it is used by other goroutines.
+func NewConfigFile(blockMode bool) *ConfigFile {
+	c := newConfigFile(nil)
+	c.BlockMode = blockMode
 	return c
 }
 
+// GetSectionComments returns the comments of section as stored,
+// including their leading "#" or ";", or empty string if it has none.
+func (c *ConfigFile) GetSectionComments(section string) string {
+	c.rlock()
+	defer c.runlock()
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	return c.sectionComments[c.sectionName(section)]
+}
+
+// GetKeyComments returns the comments of key in the given section as stored,
+// including their leading "#" or ";", or empty string if it has none.
+func (c *ConfigFile) GetKeyComments(section, key string) string {
+	c.rlock()
+	defer c.runlock()
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	section = c.sectionName(section)
+	return c.keyComments[section][c.keyName(section, key)]
+}
+
 // SetSectionComments adds new section comments to the configuration.
 // If comments are empty(0 length), it will remove its section comments!
 // It returns true if the comments were inserted or removed,
 // or returns false if the comments were overwritten.
-func (c *ConfigFile) setSectionComments(section, comments string) bool {
+func (c *ConfigFile) SetSectionComments(section, comments string) bool {
 	// Blank section name represents DEFAULT section.
 	if len(section) == 0 {
 		section = DEFAULT_SECTION
 	}
 
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+
 	if len(comments) == 0 {
 		if _, ok := c.sectionComments[section]; ok {
 			delete(c.sectionComments, section)
@@ -201,149 +395,2168 @@ func (c *ConfigFile) setSectionComments(section, comments string) bool {
 
 	// Check if comments exists.
 	_, ok := c.sectionComments[section]
-	if comments[0] != '#' && comments[0] != ';' {
-		comments = "; " + comments
-	}
+	comments = c.addCommentPrefix(comments)
 	c.sectionComments[section] = comments
 	return !ok
 }
 
+// commentPrefixes returns CommentPrefixes or the default ones.
+func (c *ConfigFile) commentPrefixes() []string {
+	if len(c.CommentPrefixes) == 0 {
+		return []string{";", "#"}
+	}
+	return c.CommentPrefixes
+}
+
+// commentPrefix returns the comment prefix which line starts with,
+// or empty string if line is not a comment.
+func (c *ConfigFile) commentPrefix(line string) string {
+	for _, prefix := range c.commentPrefixes() {
+		if len(prefix) > 0 && strings.HasPrefix(line, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// addCommentPrefix prepends the first comment prefix to comments
+// if they do not start with any of them.
+func (c *ConfigFile) addCommentPrefix(comments string) string {
+	if len(c.commentPrefix(comments)) > 0 {
+		return comments
+	}
+	return c.commentPrefixes()[0] + " " + comments
+}
+
+// addFooterComments appends comments to the end of file.
+func (c *ConfigFile) addFooterComments(comments string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	if len(c.footerComments) > 0 {
+		c.footerComments += LineBreak
+	}
+	c.footerComments += comments
+}
+
 // getValue returns the value of key available in the given section.
 // If the value needs to be unfolded
 // (see e.g. %(google)s example in the GoConfig_test.go),
 // then String does this unfolding automatically, up to
 // _DEPTH_VALUES number of iterations.
+// Variables referring back to themselves return a circular reference error.
 // It returns an error and empty string value if the section does not exist,
 // or key does not exist in DEFAULT and current sections.
+// It is safe to call on nil configuration which returns an error,
+// so package-level functions work before SetDefault is called.
 func (c *ConfigFile) getValue(section, key string) (string, error) {
-	if c.BlockMode {
-		c.lock.RLock()
-		defer c.lock.RUnlock()
+	if c == nil {
+		return "", errNoConfig
 	}
 
-	// Blank section name represents DEFAULT section.
-	if len(section) == 0 {
-		section = DEFAULT_SECTION
+	c.rlock()
+	hook := c.metricsHook
+	var start time.Time
+	if hook != nil {
+		start = time.Now()
 	}
+	value, err := c.get(context.Background(), section, key, nil)
+	c.runlock()
 
-	// Check if section exists
-	if _, ok := c.data[section]; !ok {
-		// Section does not exist.
-		return "", getError{ERR_SECTION_NOT_FOUND, section}
+	if hook != nil {
+		hook(section, key, err == nil, time.Since(start))
 	}
+	return value, err
+}
 
-	// Section exists.
-	// Check if key exists or empty value.
-	value, ok := c.data[section][key]
-	if !ok {
-		// Check if it is a sub-section.
-		if i := strings.LastIndex(section, "."); i > -1 {
-			return c.getValue(section[:i], key)
-		}
+// SetMetricsHook sets fn to be called after every lookup of getters,
+// with whether the value is found and how long the lookup takes.
+// It is called outside the lock, and nil removes the hook.
+func (c *ConfigFile) SetMetricsHook(fn func(section, key string, found bool, dur time.Duration)) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	c.metricsHook = fn
+}
 
-		// Return empty value.
-		return "", getError{ERR_KEY_NOT_FOUND, key}
+// SetFallbackSection sets the section to look up keys which do not exist
+// in the given section, it is DEFAULT section by default. Empty name
+// disables fallback entirely, including parent sections of sub-sections,
+// so keys are only found in their own and inherited sections.
+// Variables are still looked up in DEFAULT section.
+func (c *ConfigFile) SetFallbackSection(name string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
 	}
+	c.fallbackSection = name
+}
 
-	// Key exists.
-	var i int
-	for i = 0; i < _DEPTH_VALUES; i++ {
-		vr := varPattern.FindString(value)
-		if len(vr) == 0 {
-			break
-		}
+// SetEnvOverride makes getters return value of environment variable
+// named by prefix, section and key if it is set, instead of the value
+// of key, which may not exist. The name is prefix, section and key joined
+// by separator, "_" if not given, where section and key are upper-cased
+// and every character other than letters, digits and '_' is replaced by '_',
+// e.g. "MYAPP_DB_MASTER_MAX_CONNS" for key "max-conns" in section "db.master"
+// with prefix "MYAPP". Keys of DEFAULT section have no section part.
+// The value is used as is without substitution, and variables in other
+// values still refer to values in the file. Empty prefix disables it.
+func (c *ConfigFile) SetEnvOverride(prefix string, separator ...string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	c.envPrefix, c.envSeparator = prefix, "_"
+	if len(separator) > 0 {
+		c.envSeparator = separator[0]
+	}
+}
+
+// GetValue returns the value of key available in the given section,
+// variables and sub-sections are resolved as getValue does.
+func (c *ConfigFile) GetValue(section, key string) (string, error) {
+	return c.getValue(section, key)
+}
 
-		// Take off leading '%(' and trailing ')s'.
-		noption := strings.TrimLeft(vr, "%(")
-		noption = strings.TrimRight(noption, ")s")
+// GetValueReader returns a reader of the value of key available
+// in the given section, resolved as GetValue does.
+func (c *ConfigFile) GetValueReader(section, key string) (io.Reader, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(value), nil
+}
 
-		// Search variable in default section.
-		nvalue, err := c.getValue(DEFAULT_SECTION, noption)
-		if err != nil && section != DEFAULT_SECTION {
-			// Search in the same section.
-			if _, ok := c.data[section][noption]; ok {
-				nvalue = c.data[section][noption]
-			}
-		}
+// GetValueContext is like GetValue but passes ctx to Resolver,
+// and stops resolving with the context error once ctx is done.
+func (c *ConfigFile) GetValueContext(ctx context.Context, section, key string) (string, error) {
+	c.rlock()
+	defer c.runlock()
+	return c.get(ctx, section, key, nil)
+}
 
-		// Substitute by new value and take off leading '%(' and trailing ')s'.
-		value = strings.Replace(value, vr, nvalue, -1)
+// get is the lock-free part of getValue, the caller must hold the read lock.
+// If steps is not nil, the value after each substitution is appended to it.
+func (c *ConfigFile) get(ctx context.Context, section, key string, steps *[]string) (string, error) {
+	values, section, env, err := c.lookup(section, key)
+	if err != nil {
+		return "", err
 	}
-	return value, nil
+	// Value is always the last occurrence.
+	value := values[len(values)-1]
+	if env {
+		if steps != nil {
+			*steps = append(*steps, value)
+		}
+		return value, nil
+	}
+	return c.expand(ctx, section, key, value, steps, nil)
 }
 
-// SetValue adds a new section-key-value to the configuration.
-// It returns true if the key and value were inserted,
-// or returns false if the value was overwritten.
-// If the section does not exist in advance, it will be created.
-func (c *ConfigFile) setValue(section, key, value string) bool {
-	// Blank section name represents DEFAULT section.
-	if len(section) == 0 {
-		section = DEFAULT_SECTION
+// lookup returns every occurrence of key without substitution and the
+// section which holds it, the caller must hold the read lock.
+// If environment variable overrides the key, see SetEnvOverride,
+// its value is the only occurrence and env is true.
+func (c *ConfigFile) lookup(section, key string) (values []string, holder string, env bool, err error) {
+	if len(c.envPrefix) > 0 {
+		if value, ok := os.LookupEnv(c.envName(section, key)); ok {
+			return []string{value}, section, true, nil
+		}
 	}
-	if len(key) == 0 {
-		return false
+
+	value, holder, err := c.raw(section, key)
+	if err != nil {
+		return nil, "", false, err
 	}
+	if values, ok := c.keyValues[holder][c.keyName(holder, key)]; ok {
+		return values, holder, false, nil
+	}
+	return []string{value}, holder, false, nil
+}
 
-	if c.BlockMode {
-		c.lock.Lock()
-		defer c.lock.Unlock()
+// envName returns name of environment variable overrides key in the given
+// section, see SetEnvOverride.
+func (c *ConfigFile) envName(section, key string) string {
+	normalize := func(name string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+				return r
+			}
+			return '_'
+		}, strings.ToUpper(name))
 	}
 
-	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
-		// Execute add operation.
-		c.data[section] = make(map[string]string)
-		// Append section to list.
-		c.sectionList = append(c.sectionList, section)
+	name := c.envPrefix + c.envSeparator
+	// Blank section name represents DEFAULT section.
+	if len(section) > 0 && section != DEFAULT_SECTION {
+		name += normalize(section) + c.envSeparator
 	}
+	return name + normalize(key)
+}
 
-	// Check if key exists.
-	_, ok := c.data[section][key]
-	c.data[section][key] = value
-	if !ok {
-		// If not exists, append to key list.
-		c.keyList[section] = append(c.keyList[section], key)
+// varRef identifies a key by the section holding it.
+type varRef struct {
+	section, key string
+}
+
+// circularError is returned when a variable refers back to itself.
+type circularError []string
+
+func (e circularError) Error() string {
+	return "circular reference: " + strings.Join(e, " -> ")
+}
+
+// resolve is get with chain of keys being resolved by the callers,
+// which is used to detect circular references.
+func (c *ConfigFile) resolve(ctx context.Context, section, key string, steps *[]string, chain []varRef) (string, error) {
+	value, section, err := c.raw(section, key)
+	if err != nil {
+		return "", err
 	}
-	return !ok
+	return c.expand(ctx, section, key, value, steps, chain)
 }
 
-// SetKeyComments adds new section-key comments to the configuration.
-// If comments are empty(0 length), it will remove its section-key comments!
-// It returns true if the comments were inserted or removed,
-// or returns false if the comments were overwritten.
-// If the section does not exist in advance, it is created.
-func (c *ConfigFile) setKeyComments(section, key, comments string) bool {
-	// Blank section name represents DEFAULT section.
-	if len(section) == 0 {
-		section = DEFAULT_SECTION
+// expand substitutes variables in value of key held by section.
+func (c *ConfigFile) expand(ctx context.Context, section, key, value string, steps *[]string, chain []varRef) (string, error) {
+	ref := varRef{section, c.keyName(section, key)}
+	for i := range chain {
+		if chain[i] == ref {
+			names := make(circularError, 0, len(chain)-i+1)
+			for _, r := range chain[i:] {
+				names = append(names, r.key)
+			}
+			return "", append(names, ref.key)
+		}
 	}
+	chain = append(chain[:len(chain):len(chain)], ref)
 
-	// Check if section exists.
-	if _, ok := c.keyComments[section]; ok {
-		if len(comments) == 0 {
-			if _, ok := c.keyComments[section][key]; ok {
-				delete(c.keyComments[section], key)
+	// Key exists.
+	if steps != nil {
+		*steps = append(*steps, value)
+	}
+	var i int
+	for i = 0; i < _DEPTH_VALUES; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		matches := varPattern.FindAllStringSubmatch(value, -1)
+		if len(matches) == 0 {
+			if !c.ExpandEnv || !envPattern.MatchString(value) {
+				break
 			}
 
-			// Not exists can be seen as remove.
-			return true
+			// Expand environment variables, their values may have
+			// more variables so it takes one iteration as well.
+			var err error
+			if value, err = c.expandEnv(ctx, value); err != nil {
+				return "", err
+			}
+			if steps != nil {
+				*steps = append(*steps, value)
+			}
+			continue
 		}
-	} else {
-		if len(comments) == 0 {
-			// Not exists can be seen as remove.
-			return true
-		} else {
-			// Execute add operation.
-			c.keyComments[section] = make(map[string]string)
+
+		// Substitute every variable in one pass, the name is the first submatch.
+		pairs := make([]string, 0, 2*len(matches))
+		resolved := make(map[string]bool, len(matches))
+		for _, m := range matches {
+			if resolved[m[0]] {
+				continue
+			}
+			nvalue, err := c.variable(ctx, section, m[1], chain)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, m[0], nvalue)
+			resolved[m[0]] = true
+		}
+		value = strings.NewReplacer(pairs...).Replace(value)
+		if steps != nil {
+			*steps = append(*steps, value)
 		}
 	}
 
-	// Check if key exists.
-	_, ok := c.keyComments[section][key]
-	if comments[0] != '#' && comments[0] != ';' {
-		comments = "; " + comments
+	if c.FileRefs && strings.HasPrefix(value, FILE_REF_PREFIX) {
+		return c.readFileRef(value[len(FILE_REF_PREFIX):])
 	}
+	return value, nil
+}
+
+// variable returns the value of variable name in value of key held by
+// section, it is empty if name is not defined unless StrictVars is set.
+func (c *ConfigFile) variable(ctx context.Context, section, name string, chain []varRef) (string, error) {
+	// Search variable in default section.
+	value, err := c.resolve(ctx, DEFAULT_SECTION, name, nil, chain)
+	// Only a missing variable is looked up further, any other error,
+	// e.g. from Resolver, is returned as is.
+	if err == nil || !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrSectionNotFound) {
+		return value, err
+	}
+
+	// Search in the same section.
+	if _, ok := c.data[section][c.keyName(section, name)]; ok && section != DEFAULT_SECTION {
+		return c.resolve(ctx, section, name, nil, chain)
+	}
+	if c.StrictVars {
+		return "", fmt.Errorf("variable '%s' not defined", name)
+	}
+	return "", nil
+}
+
+// readFileRef returns contents of file name without trailing line break,
+// contents are cached until ClearFileRefs or Reload is called.
+func (c *ConfigFile) readFileRef(name string) (string, error) {
+	c.fileRefsLock.Lock()
+	defer c.fileRefsLock.Unlock()
+
+	if value, ok := c.fileRefs[name]; ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("file reference: %v", err)
+	}
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if c.fileRefs == nil {
+		c.fileRefs = make(map[string]string)
+	}
+	c.fileRefs[name] = value
+	return value, nil
+}
+
+// ClearFileRefs drops cached contents of files referenced by values,
+// so they are read again next time. See FileRefs.
+func (c *ConfigFile) ClearFileRefs() {
+	c.fileRefsLock.Lock()
+	c.fileRefs = nil
+	c.fileRefsLock.Unlock()
+}
+
+// GetNamespaced returns the value of namespaced key "prefix.key",
+// so flat keys still work while migrating to namespaced keys.
+// It looks up in order:
+//  1. "prefix.key" in section
+//  2. "prefix.key" in DEFAULT section
+//  3. "key" in section
+//
+// The error of the last lookup is returned if none of them exists.
+func (c *ConfigFile) GetNamespaced(section, prefix, key string) (string, error) {
+	c.rlock()
+	defer c.runlock()
+
+	ctx := context.Background()
+	if value, err := c.get(ctx, section, prefix+"."+key, nil); err == nil {
+		return value, nil
+	}
+	if value, err := c.get(ctx, DEFAULT_SECTION, prefix+"."+key, nil); err == nil {
+		return value, nil
+	}
+	return c.get(ctx, section, key, nil)
+}
+
+// GetValueOnce returns the value of key like GetValue, but substitutes
+// every %(name)s in the value only once with the raw value of name,
+// so references which come from the substituted values are kept literally.
+// GetValue instead repeats substitution up to _DEPTH_VALUES times.
+// Environment variables are not expanded.
+func (c *ConfigFile) GetValueOnce(section, key string) (string, error) {
+	c.rlock()
+	defer c.runlock()
+
+	values, section, env, err := c.lookup(section, key)
+	if err != nil {
+		return "", err
+	}
+	value := values[len(values)-1]
+	// Value of environment variable is not substituted.
+	if env {
+		return value, nil
+	}
+	value = varPattern.ReplaceAllStringFunc(value, func(vr string) string {
+		name := vr[2 : len(vr)-2]
+		// Search variable in default section, then in the same section.
+		if nvalue, _, err := c.raw(DEFAULT_SECTION, name); err == nil {
+			return nvalue
+		}
+		nvalue, ok := c.data[section][c.keyName(section, name)]
+		if !ok && c.StrictVars && err == nil {
+			err = fmt.Errorf("variable '%s' not defined", name)
+		}
+		return nvalue
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// raw returns the value of key without substitution and the section
+// which holds it, the caller must hold the read lock.
+func (c *ConfigFile) raw(section, key string) (string, string, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	section = c.sectionName(section)
+
+	// Check if section exists
+	if _, ok := c.data[section]; !ok {
+		// Section does not exist.
+		return "", "", getError{ERR_SECTION_NOT_FOUND, section}
+	}
+
+	// Section exists.
+	// Check if key exists or empty value.
+	value, ok := c.data[section][c.keyName(section, key)]
+	if c.DefaultOverrides && section != DEFAULT_SECTION {
+		// DEFAULT section wins over current section.
+		if v, found := c.data[DEFAULT_SECTION][c.keyName(DEFAULT_SECTION, key)]; found {
+			return v, DEFAULT_SECTION, nil
+		}
+	}
+	if !ok {
+		// Search in inherited sections.
+		if v, s, found := c.inherited(section, key); found {
+			return v, s, nil
+		}
+
+		// Fallback is disabled.
+		if len(c.fallbackSection) == 0 {
+			return "", "", getError{ERR_KEY_NOT_FOUND, key}
+		}
+
+		// Check if it is a sub-section.
+		if i := strings.LastIndex(section, "."); i > -1 {
+			if v, s, err := c.raw(section[:i], key); err == nil {
+				return v, s, nil
+			}
+		}
+
+		// Search in fallback section, which is DEFAULT section by default.
+		if fallback := c.sectionName(c.fallbackSection); section != fallback {
+			if v, ok := c.data[fallback][c.keyName(fallback, key)]; ok {
+				return v, fallback, nil
+			}
+		}
+
+		// Return empty value.
+		return "", "", getError{ERR_KEY_NOT_FOUND, key}
+	}
+	return value, section, nil
+}
+
+// inherited looks up key in sections inherited by section in turn,
+// see SetSectionParent. It returns the value and the section which holds it.
+func (c *ConfigFile) inherited(section, key string) (string, string, bool) {
+	// Bounded by number of parents in case they form a loop.
+	for n := 0; n < len(c.sectionParents); n++ {
+		parent, ok := c.sectionParents[section]
+		if !ok {
+			break
+		}
+		section = c.sectionName(parent)
+		if v, ok := c.data[section][c.keyName(section, key)]; ok {
+			return v, section, true
+		}
+	}
+	return "", "", false
+}
+
+// SetSectionParent makes section inherit keys of parent section,
+// which are looked up when a key is missing in section before
+// its parent sub-section and DEFAULT section. Empty parent removes it.
+// In file it is declared by the header annotation "[section] ; @inherits=parent".
+func (c *ConfigFile) SetSectionParent(section, parent string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	section = c.sectionName(section)
+	if len(parent) == 0 {
+		delete(c.sectionParents, section)
+		return
+	}
+	c.sectionParents[section] = parent
+}
+
+// expandEnv replaces every ${NAME} in value with environment variable NAME,
+// or the result of Resolver if it is set.
+func (c *ConfigFile) expandEnv(ctx context.Context, value string) (string, error) {
+	var err error
+	value = envPattern.ReplaceAllStringFunc(value, func(vr string) string {
+		name := vr[2 : len(vr)-1]
+		if c.Resolver != nil {
+			if err != nil {
+				return ""
+			}
+			if err = ctx.Err(); err != nil {
+				return ""
+			}
+			var env string
+			env, err = c.Resolver(ctx, name)
+			return env
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok && c.StrictVars && err == nil {
+			err = fmt.Errorf("environment variable '%s' not defined", name)
+		}
+		return env
+	})
+	return value, err
+}
+
+// GetValueRawQuotes returns the value like GetValue, but keeps the quotes
+// which wrap the value in the file, e.g. "`abc`" instead of "abc".
+// Values that are not quoted in the file are returned as GetValue does.
+func (c *ConfigFile) GetValueRawQuotes(section, key string) (string, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	c.rlock()
+	defer c.runlock()
+
+	values, section, env, err := c.lookup(section, key)
+	if err != nil {
+		return "", err
+	}
+	value := values[len(values)-1]
+	// Value of environment variable has no quotes.
+	if env {
+		return value, nil
+	}
+	if value, err = c.expand(context.Background(), section, key, value, nil, nil); err != nil {
+		return "", err
+	}
+	quote := c.keyQuotes[section][c.keyName(section, key)]
+	return quote + value + quote, nil
+}
+
+// ExplainValue returns a human-readable trace of how the value of key
+// in the given section is resolved, one line per substitution step,
+// which helps finding out why a substituted value is empty or wrong.
+func (c *ConfigFile) ExplainValue(section, key string) string {
+	c.rlock()
+	defer c.runlock()
+
+	var steps []string
+	_, err := c.get(context.Background(), section, key, &steps)
+	if err != nil {
+		return fmt.Sprintf("[%s] %s: %v", section, key, err)
+	}
+
+	trace := fmt.Sprintf("[%s] %s = %s", section, key, steps[0])
+	for _, step := range steps[1:] {
+		trace += LineBreak + "-> " + step
+	}
+	return trace
+}
+
+// ReferencesOf returns every section-key whose raw value contains %(varName)s,
+// in the order of sections and keys.
+func (c *ConfigFile) ReferencesOf(varName string) []Entry {
+	c.rlock()
+	defer c.runlock()
+
+	ref := "%(" + varName + ")s"
+	var entries []Entry
+	for _, section := range c.sectionList {
+		for _, key := range c.keyList[section] {
+			if value := c.data[section][key]; strings.Contains(value, ref) {
+				entries = append(entries, Entry{section, key, value})
+			}
+		}
+	}
+	return entries
+}
+
+// GetSection returns a copy of key-value map of the given section,
+// with variables substituted in every value.
+func (c *ConfigFile) GetSection(section string) (map[string]string, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	c.rlock()
+	defer c.runlock()
+	return c.section(section)
+}
+
+// section is the lock-free part of GetSection.
+func (c *ConfigFile) section(section string) (map[string]string, error) {
+	section = c.sectionName(section)
+	// Check if section exists.
+	if _, ok := c.data[section]; !ok {
+		return nil, getError{ERR_SECTION_NOT_FOUND, section}
+	}
+
+	ctx := context.Background()
+	values := make(map[string]string, len(c.keyList[section]))
+	for _, key := range c.keyList[section] {
+		value, err := c.get(ctx, section, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// GetSectionArray returns copies of all sections of the array name,
+// in the order they appear in the file. See SectionArrays.
+func (c *ConfigFile) GetSectionArray(name string) []map[string]string {
+	c.rlock()
+	defer c.runlock()
+
+	var sections []map[string]string
+	for i := 0; ; i++ {
+		values, err := c.section(arraySection(name, i))
+		if err != nil {
+			break
+		}
+		sections = append(sections, values)
+	}
+	return sections
+}
+
+// arraySection returns section name of the element at index of array name.
+func arraySection(name string, index int) string {
+	return name + "[" + strconv.Itoa(index) + "]"
+}
+
+// nextArraySection returns section name of a new element of array name.
+func (c *ConfigFile) nextArraySection(name string) string {
+	c.rlock()
+	defer c.runlock()
+
+	i := 0
+	for {
+		if _, ok := c.data[c.sectionName(arraySection(name, i))]; !ok {
+			return arraySection(name, i)
+		}
+		i++
+	}
+}
+
+// Resolved returns a deep copy of all sections and keys with variables
+// substituted, so it can be read many times without locking or resolution.
+// It is a point-in-time snapshot and does not follow later changes.
+// Keys whose value fails to resolve, e.g. under StrictVars, are left out.
+func (c *ConfigFile) Resolved() map[string]map[string]string {
+	c.rlock()
+	defer c.runlock()
+	return c.resolved()
+}
+
+// resolved is the lock-free part of Resolved.
+func (c *ConfigFile) resolved() map[string]map[string]string {
+	resolved := make(map[string]map[string]string, len(c.sectionList))
+	for _, section := range c.sectionList {
+		keys := make(map[string]string, len(c.keyList[section]))
+		for _, key := range c.keyList[section] {
+			if value, err := c.get(context.Background(), section, key, nil); err == nil {
+				keys[key] = value
+			}
+		}
+		resolved[section] = keys
+	}
+	return resolved
+}
+
+// Range calls fn for every key in order with its value substituted
+// like GetValue, until fn returns false. Keys whose value fails to resolve
+// are skipped. It walks a snapshot taken under the read lock, so fn sees
+// a consistent view and may call any method of c, including setters.
+func (c *ConfigFile) Range(fn func(section, key, value string) bool) {
+	c.rlock()
+	var entries []Entry
+	for _, section := range c.sectionList {
+		for _, key := range c.keyList[section] {
+			if value, err := c.get(context.Background(), section, key, nil); err == nil {
+				entries = append(entries, Entry{section, key, value})
+			}
+		}
+	}
+	c.runlock()
+
+	for _, e := range entries {
+		if !fn(e.Section, e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// EnabledFlags returns names of keys whose value is true in the given
+// section in order, followed by such keys of fallback section, DEFAULT
+// section by default, which are not set in the section.
+// Values which are not bool are ignored.
+func (c *ConfigFile) EnabledFlags(section string) []string {
+	c.rlock()
+	defer c.runlock()
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	section = c.sectionName(section)
+	if _, ok := c.data[section]; !ok {
+		return nil
+	}
+
+	ctx := context.Background()
+	var flags []string
+	for _, key := range c.keyList[section] {
+		if value, err := c.get(ctx, section, key, nil); err == nil {
+			if b, err := parseBool(value); err == nil && b {
+				flags = append(flags, key)
+			}
+		}
+	}
+	// Fallback is disabled.
+	if len(c.fallbackSection) == 0 {
+		return flags
+	}
+	fallback := c.sectionName(c.fallbackSection)
+	if section == fallback {
+		return flags
+	}
+	for _, key := range c.keyList[fallback] {
+		if _, ok := c.data[section][c.keyName(section, key)]; ok {
+			continue
+		}
+		if value, err := c.get(ctx, fallback, key, nil); err == nil {
+			if b, err := parseBool(value); err == nil && b {
+				flags = append(flags, key)
+			}
+		}
+	}
+	return flags
+}
+
+// Order returns a copy of key names of every section in their order,
+// it is the ordering counterpart of Resolved.
+func (c *ConfigFile) Order() map[string][]string {
+	c.rlock()
+	defer c.runlock()
+
+	order := make(map[string][]string, len(c.sectionList))
+	for _, section := range c.sectionList {
+		order[section] = append([]string{}, c.keyList[section]...)
+	}
+	return order
+}
+
+// GetValueAt returns the occurrence at index of key in the given section.
+// Index is 0-based and negative index counts from the end.
+// It returns an error if index is out of range.
+// Keys hold exactly one occurrence unless MultiValues is set.
+func (c *ConfigFile) GetValueAt(section, key string, index int) (string, error) {
+	values, err := c.GetValues(section, key)
+	if err != nil {
+		return "", err
+	}
+	if index < 0 {
+		index += len(values)
+	}
+	if index < 0 || index >= len(values) {
+		return "", fmt.Errorf("index %d out of range for key '%s'", index, key)
+	}
+	return values[index], nil
+}
+
+// GetValues returns every occurrence of key in the given section in order,
+// each one is resolved as GetValue does. Keys hold exactly one occurrence
+// unless MultiValues is set.
+func (c *ConfigFile) GetValues(section, key string) ([]string, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	c.rlock()
+	defer c.runlock()
+
+	raws, section, env, err := c.lookup(section, key)
+	if err != nil || env {
+		return raws, err
+	}
+
+	values := make([]string, len(raws))
+	for i, raw := range raws {
+		if values[i], err = c.expand(context.Background(), section, key, raw, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// GetStrings returns the value split by delim with spaces trimmed,
+// empty elements are dropped. It returns an empty slice if any error occurs.
+func (c *ConfigFile) GetStrings(section, key, delim string) []string {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return []string{}
+	}
+
+	vals := make([]string, 0, strings.Count(value, delim)+1)
+	for _, v := range strings.Split(value, delim) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// GetInts returns the value split by delim and parsed as integers,
+// it returns an error if any element is not an integer.
+func (c *ConfigFile) GetInts(section, key, delim string) ([]int, error) {
+	strs := c.GetStrings(section, key, delim)
+	vals := make([]int, len(strs))
+	for i, str := range strs {
+		v, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// GetFloat64s returns the value split by delim and parsed as float64,
+// it returns an error if any element is not a float.
+func (c *ConfigFile) GetFloat64s(section, key, delim string) ([]float64, error) {
+	strs := c.GetStrings(section, key, delim)
+	vals := make([]float64, len(strs))
+	for i, str := range strs {
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// GetJSONStrings returns the value decoded as a JSON array of strings,
+// e.g. hosts = ["a", "b"]. Wrap the value with backticks in the file
+// to keep its leading and trailing spaces or quote characters intact.
+// It returns the JSON error if the value is malformed.
+func (c *ConfigFile) GetJSONStrings(section, key string) ([]string, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var vals []string
+	if err = json.Unmarshal([]byte(value), &vals); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// GetJSONInts returns the value decoded as a JSON array of integers,
+// e.g. ports = [80, 443]. See GetJSONStrings for quoting.
+func (c *ConfigFile) GetJSONInts(section, key string) ([]int, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var vals []int
+	if err = json.Unmarshal([]byte(value), &vals); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// GetTriBool returns tri-state bool type value: 1 for true, 0 for false
+// and -1 for "auto", "default" or "unset" (case-insensitive).
+// True and false accept the same values as Bool.
+// It returns an error if the value is none of them.
+func (c *ConfigFile) GetTriBool(section, key string) (state int, err error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return -1, err
+	}
+
+	switch strings.ToLower(value) {
+	case "auto", "default", "unset":
+		return -1, nil
+	}
+	b, err := parseBool(value)
+	if err != nil {
+		return -1, err
+	}
+	if b {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Bool returns bool type value.
+// It accepts true/false, t/f, yes/no, y/n, on/off, 1/0 and
+// enabled/disabled in any case.
+func (c *ConfigFile) Bool(section, key string) (bool, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return false, err
+	}
+	return parseBool(value)
+}
+
+// parseBool returns the bool value represented by value, see Bool.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "t", "yes", "y", "on", "1", "enabled":
+		return true, nil
+	case "false", "f", "no", "n", "off", "0", "disabled":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid bool value '%s'", value)
+}
+
+// Float64 returns float64 type value.
+func (c *ConfigFile) Float64(section, key string) (float64, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0.0, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Int returns int type value.
+func (c *ConfigFile) Int(section, key string) (int, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// Int64 returns int64 type value.
+func (c *ConfigFile) Int64(section, key string) (int64, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Uint returns uint type value, negative values are an error.
+func (c *ConfigFile) Uint(section, key string) (uint, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(value, 10, 0)
+	return uint(n), err
+}
+
+// Uint64 returns uint64 type value, negative values are an error.
+func (c *ConfigFile) Uint64(section, key string) (uint64, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// Duration returns time.Duration type value, e.g. "1h30m".
+func (c *ConfigFile) Duration(section, key string) (time.Duration, error) {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(value)
+}
+
+// MustValue always returns value without error.
+// It returns empty string if error occurs, or the default value if given.
+func (c *ConfigFile) MustValue(section, key string, defaultVal ...string) string {
+	val, err := c.getValue(section, key)
+	if len(defaultVal) > 0 && (err != nil || len(val) == 0) {
+		return defaultVal[0]
+	}
+	return val
+}
+
+// MustBool always returns value without error,
+// it returns false if error occurs.
+func (c *ConfigFile) MustBool(section, key string, defaultVal ...bool) bool {
+	val, err := c.Bool(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return val
+}
+
+// MustFloat64 always returns value without error,
+// it returns 0.0 if error occurs.
+func (c *ConfigFile) MustFloat64(section, key string, defaultVal ...float64) float64 {
+	value, err := c.Float64(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustInt always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustInt(section, key string, defaultVal ...int) int {
+	value, err := c.Int(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustInt64 always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustInt64(section, key string, defaultVal ...int64) int64 {
+	value, err := c.Int64(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustUint always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustUint(section, key string, defaultVal ...uint) uint {
+	value, err := c.Uint(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustUint64 always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustUint64(section, key string, defaultVal ...uint64) uint64 {
+	value, err := c.Uint64(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustDuration always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustDuration(section, key string, defaultVal ...time.Duration) time.Duration {
+	value, err := c.Duration(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// GetValueOrDefault returns the value of key available in the given section,
+// or def if any error occurs, including the section or key does not exist.
+func (c *ConfigFile) GetValueOrDefault(section, key, def string) string {
+	value, err := c.getValue(section, key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// GetValueWithDefault is like GetValueOrDefault, but also reports whether
+// the key was found, so an empty value can be told from a missing key.
+// It returns def and false only if the section or key does not exist.
+// Other errors, e.g. undefined variables with StrictVars, return def and true.
+func (c *ConfigFile) GetValueWithDefault(section, key, def string) (string, bool) {
+	value, err := c.getValue(section, key)
+	if errors.Is(err, ErrSectionNotFound) || errors.Is(err, ErrKeyNotFound) {
+		return def, false
+	}
+	if err != nil {
+		return def, true
+	}
+	return value, true
+}
+
+// GetBoolOrDefault returns bool type value, or def if any error occurs.
+func (c *ConfigFile) GetBoolOrDefault(section, key string, def bool) bool {
+	b, err := c.Bool(section, key)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// GetFloat64OrDefault returns float64 type value, or def if any error occurs.
+func (c *ConfigFile) GetFloat64OrDefault(section, key string, def float64) float64 {
+	f, err := c.Float64(section, key)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// GetIntOrDefault returns int type value, or def if any error occurs.
+func (c *ConfigFile) GetIntOrDefault(section, key string, def int) int {
+	i, err := c.Int(section, key)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// GetIntInRange returns int type value,
+// it returns an error if the value is not in [min, max].
+func (c *ConfigFile) GetIntInRange(section, key string, min, max int) (int, error) {
+	i, err := c.Int(section, key)
+	if err != nil {
+		return 0, err
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("value %d of key '%s' out of range [%d, %d]", i, key, min, max)
+	}
+	return i, nil
+}
+
+// MustIntInRange always returns value in [min, max] without error.
+// Value out of range is clamped to min or max, and if any other error
+// occurs, it returns the default value if given, or min otherwise.
+func (c *ConfigFile) MustIntInRange(section, key string, min, max int, defaultVal ...int) int {
+	i, err := c.Int(section, key)
+	switch {
+	case err != nil && len(defaultVal) > 0:
+		return defaultVal[0]
+	case err != nil, i < min:
+		return min
+	case i > max:
+		return max
+	}
+	return i
+}
+
+// IntOrLog returns int type value, or def if any error occurs,
+// in which case the error is passed to log if it is not nil.
+func (c *ConfigFile) IntOrLog(section, key string, def int, log func(error)) int {
+	i, err := c.Int(section, key)
+	if err != nil {
+		logError(log, section, key, err)
+		return def
+	}
+	return i
+}
+
+// BoolOrLog returns bool type value, or def and logs the error like IntOrLog.
+func (c *ConfigFile) BoolOrLog(section, key string, def bool, log func(error)) bool {
+	b, err := c.Bool(section, key)
+	if err != nil {
+		logError(log, section, key, err)
+		return def
+	}
+	return b
+}
+
+// Float64OrLog returns float64 type value, or def and logs the error like IntOrLog.
+func (c *ConfigFile) Float64OrLog(section, key string, def float64, log func(error)) float64 {
+	f, err := c.Float64(section, key)
+	if err != nil {
+		logError(log, section, key, err)
+		return def
+	}
+	return f
+}
+
+// DurationOrLog returns time.Duration type value, or def and logs the error like IntOrLog.
+func (c *ConfigFile) DurationOrLog(section, key string, def time.Duration, log func(error)) time.Duration {
+	d, err := c.Duration(section, key)
+	if err != nil {
+		logError(log, section, key, err)
+		return def
+	}
+	return d
+}
+
+// logError passes err with section and key to log if it is not nil.
+func logError(log func(error), section, key string, err error) {
+	if log != nil {
+		log(fmt.Errorf("[%s] %s: %w", section, key, err))
+	}
+}
+
+// ValueType returns the type declared for key in the given section,
+// or empty string if it has no type annotation.
+// See TypeAnnotations for the annotation syntax.
+func (c *ConfigFile) ValueType(section, key string) string {
+	c.rlock()
+	defer c.runlock()
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	section = c.sectionName(section)
+	return c.keyTypes[section][c.keyName(section, key)]
+}
+
+// setKeyType records the declared type of section-key.
+func (c *ConfigFile) setKeyType(section, key, typ string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	key = c.keyName(section, key)
+	if _, ok := c.keyTypes[section]; !ok {
+		c.keyTypes[section] = make(map[string]string)
+	}
+	c.keyTypes[section][key] = typ
+}
+
+// setKeyQuote records the quote which wraps value of section-key in the file,
+// so it is written back the same way.
+func (c *ConfigFile) setKeyQuote(section, key, quote string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	key = c.keyName(section, key)
+	if _, ok := c.keyQuotes[section]; !ok {
+		c.keyQuotes[section] = make(map[string]string)
+	}
+	c.keyQuotes[section][key] = quote
+}
+
+// SetValue adds a new section-key-value to the configuration.
+// It returns true if the key and value were inserted,
+// or returns false if the value was overwritten.
+// If the section does not exist in advance, it will be created.
+// With StrictNames, it returns false without any change if section or key
+// name is invalid, see CheckSectionName and CheckKeyName.
+func (c *ConfigFile) SetValue(section, key, value string) bool {
+	if !c.validNames(section, key) {
+		return false
+	}
+	return c.setValue(section, key, value)
+}
+
+// validNames reports whether section and key names are allowed,
+// they are always allowed unless StrictNames is set.
+func (c *ConfigFile) validNames(section, key string) bool {
+	return !c.StrictNames || CheckSectionName(section) == nil && CheckKeyName(key, false) == nil
+}
+
+// setValue is SetValue without checking names.
+func (c *ConfigFile) setValue(section, key, value string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	if len(key) == 0 {
+		return false
+	}
+
+	if c.BlockMode && c.ShardedLocks && c.overwrite(section, key, value) {
+		return false
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	return c.set(section, key, value)
+}
+
+// CheckSectionName returns an error if section name contains ']'
+// or line breaks, which can't be read back after saving unless the
+// section header is quoted, e.g. ["a]b"], or can't be saved at all.
+func CheckSectionName(section string) error {
+	if strings.ContainsAny(section, "]\r\n") {
+		return fmt.Errorf("invalid section name %q: contains ']' or line break", section)
+	}
+	return nil
+}
+
+// CheckKeyName returns an error if key name contains line breaks,
+// or delimiter '=' or ':' when the key is not quoted.
+func CheckKeyName(key string, quoted bool) error {
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("invalid key name %q: contains line break", key)
+	}
+	if !quoted && strings.ContainsAny(key, "=:") {
+		return fmt.Errorf("invalid key name %q: contains delimiter", key)
+	}
+	return nil
+}
+
+// DeleteKey deletes the key and its comments in the given section.
+// It returns true if the key was deleted,
+// or returns false if the section or key does not exist.
+func (c *ConfigFile) DeleteKey(section, key string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	key = c.keyName(section, key)
+	// Check if key exists.
+	if _, ok := c.data[section][key]; !ok {
+		return false
+	}
+	delete(c.data[section], key)
+	delete(c.keyComments[section], key)
+	delete(c.keyTypes[section], key)
+	delete(c.keyQuotes[section], key)
+	delete(c.keyValues[section], key)
+
+	// Remove from key list.
+	keys := c.keyList[section]
+	for i, k := range keys {
+		if k == key {
+			c.keyList[section] = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// DeleteSection deletes the section with all its keys and comments.
+// It returns true if the section was deleted,
+// or returns false if the section does not exist.
+func (c *ConfigFile) DeleteSection(section string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	// Check if section exists.
+	if _, ok := c.data[section]; !ok {
+		return false
+	}
+	delete(c.data, section)
+	delete(c.keyList, section)
+	delete(c.sectionComments, section)
+	delete(c.sectionParents, section)
+	delete(c.keyComments, section)
+	delete(c.keyTypes, section)
+	delete(c.keyQuotes, section)
+	delete(c.keyValues, section)
+	delete(c.sectionLocks, section)
+
+	// Remove from section list.
+	for i, s := range c.sectionList {
+		if s == section {
+			c.sectionList = append(c.sectionList[:i], c.sectionList[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// OrphanedComments returns comments whose section or key does not exist,
+// as "[section]" for section comments and "[section] key" for key comments,
+// sorted by name.
+func (c *ConfigFile) OrphanedComments() []string {
+	c.rlock()
+	defer c.runlock()
+
+	var orphans []string
+	for section := range c.sectionComments {
+		if _, ok := c.data[section]; !ok {
+			orphans = append(orphans, "["+section+"]")
+		}
+	}
+	for section, keys := range c.keyComments {
+		for key := range keys {
+			if _, ok := c.data[section][key]; !ok {
+				orphans = append(orphans, "["+section+"] "+key)
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// overwrite sets value of an existing key with only its section locked.
+// It returns false without any change if the key does not exist.
+func (c *ConfigFile) overwrite(section, key, value string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	section = c.sectionName(section)
+	l, ok := c.sectionLocks[section]
+	if !ok {
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	key = c.keyName(section, key)
+	if _, ok = c.data[section][key]; ok {
+		c.data[section][key] = value
+		delete(c.keyValues[section], key)
+		delete(c.keyQuotes[section], key)
+	}
+	return ok
+}
+
+// rlock locks c for reading. In sharded mode it also locks every section,
+// so no key is being overwritten while reading.
+func (c *ConfigFile) rlock() {
+	if !c.BlockMode {
+		return
+	}
+	c.lock.RLock()
+	// Sections are always locked in the same order.
+	for _, section := range c.sectionList {
+		if l, ok := c.sectionLocks[section]; ok {
+			l.RLock()
+		}
+	}
+}
+
+// runlock undoes rlock.
+func (c *ConfigFile) runlock() {
+	if !c.BlockMode {
+		return
+	}
+	for _, section := range c.sectionList {
+		if l, ok := c.sectionLocks[section]; ok {
+			l.RUnlock()
+		}
+	}
+	c.lock.RUnlock()
+}
+
+// set is the lock-free part of SetValue, the caller must hold the write lock.
+func (c *ConfigFile) set(section, key, value string) bool {
+	section = c.ensureSection(section)
+	key = c.keyName(section, key)
+
+	// Check if key exists.
+	_, ok := c.data[section][key]
+	c.data[section][key] = value
+	delete(c.keyValues[section], key)
+	// Quote of the file may not hold the new value.
+	delete(c.keyQuotes[section], key)
+	if !ok {
+		// If not exists, append to key list.
+		c.keyList[section] = append(c.keyList[section], key)
+	}
+	return !ok
+}
+
+// ensureSection creates the section if it does not exist and returns
+// its name as stored, the caller must hold the write lock.
+func (c *ConfigFile) ensureSection(section string) string {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	section = c.sectionName(section)
+	// Check if section exists.
+	if _, ok := c.data[section]; !ok {
+		// Execute add operation.
+		c.data[section] = make(map[string]string)
+		// Append section to list.
+		c.sectionList = append(c.sectionList, section)
+	}
+	if c.ShardedLocks && c.sectionLocks[section] == nil {
+		if c.sectionLocks == nil {
+			c.sectionLocks = make(map[string]*sync.RWMutex)
+		}
+		c.sectionLocks[section] = new(sync.RWMutex)
+	}
+	return section
+}
+
+// sectionName returns the stored name of section, which differs only
+// in case with CaseInsensitive, or section itself if it does not exist.
+func (c *ConfigFile) sectionName(section string) string {
+	if !c.CaseInsensitive {
+		return section
+	}
+	if _, ok := c.data[section]; ok {
+		return section
+	}
+	if strings.EqualFold(section, DEFAULT_SECTION) {
+		return DEFAULT_SECTION
+	}
+	for _, s := range c.sectionList {
+		if strings.EqualFold(s, section) {
+			return s
+		}
+	}
+	return section
+}
+
+// keyName returns the stored name of key in the given stored section,
+// like sectionName does.
+func (c *ConfigFile) keyName(section, key string) string {
+	if !c.CaseInsensitive {
+		return key
+	}
+	if _, ok := c.data[section][key]; ok {
+		return key
+	}
+	for _, k := range c.keyList[section] {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// addSection creates the section if it does not exist.
+func (c *ConfigFile) addSection(section string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	c.ensureSection(section)
+}
+
+// An Entry represents a single section-key-value of the configuration.
+type Entry struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+// SetMany adds or overwrites all entries while holding the write lock once,
+// so readers see either none or all of the batch.
+// It returns the number of inserted and overwritten keys,
+// entries with blank key name or names not allowed by StrictNames are skipped.
+func (c *ConfigFile) SetMany(entries []Entry) (inserted, overwritten int) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	for _, e := range entries {
+		if len(e.Key) == 0 || !c.validNames(e.Section, e.Key) {
+			continue
+		}
+		// Blank section name represents DEFAULT section.
+		section := e.Section
+		if len(section) == 0 {
+			section = DEFAULT_SECTION
+		}
+
+		if c.set(section, e.Key, e.Value) {
+			inserted++
+		} else {
+			overwritten++
+		}
+	}
+	return inserted, overwritten
+}
+
+// SetValues adds or overwrites all keys of kv in the given section
+// like SetMany, new keys are appended in sorted order.
+func (c *ConfigFile) SetValues(section string, kv map[string]string) {
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = Entry{section, key, kv[key]}
+	}
+	c.SetMany(entries)
+}
+
+// TransformValues calls fn for every key in order with its value before
+// substitution, and replaces the value with the returned one if fn
+// returns true. Key with several values, see MultiValues, has fn called
+// for each of them. It holds the write lock for the whole pass, so fn
+// must not call any method of c.
+func (c *ConfigFile) TransformValues(fn func(section, key, value string) (string, bool)) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	for _, section := range c.sectionList {
+		for _, key := range c.keyList[section] {
+			if values, ok := c.keyValues[section][key]; ok {
+				for i, value := range values {
+					if value, ok = fn(section, key, value); ok {
+						values[i] = value
+					}
+				}
+				// Value of the key is always the last one.
+				c.data[section][key] = values[len(values)-1]
+				continue
+			}
+			if value, ok := fn(section, key, c.data[section][key]); ok {
+				c.data[section][key] = value
+			}
+		}
+	}
+}
+
+// SetKeyComments adds new section-key comments to the configuration.
+// If comments are empty(0 length), it will remove its section-key comments!
+// It returns true if the comments were inserted or removed,
+// or returns false if the comments were overwritten.
+// If the section does not exist in advance, it is created.
+func (c *ConfigFile) SetKeyComments(section, key, comments string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	key = c.keyName(section, key)
+
+	// Check if section exists.
+	if _, ok := c.keyComments[section]; ok {
+		if len(comments) == 0 {
+			if _, ok := c.keyComments[section][key]; ok {
+				delete(c.keyComments[section], key)
+			}
+
+			// Not exists can be seen as remove.
+			return true
+		}
+	} else {
+		if len(comments) == 0 {
+			// Not exists can be seen as remove.
+			return true
+		} else {
+			// Execute add operation.
+			c.keyComments[section] = make(map[string]string)
+		}
+	}
+
+	// Check if key exists.
+	_, ok := c.keyComments[section][key]
+	comments = c.addCommentPrefix(comments)
 	c.keyComments[section][key] = comments
 	return !ok
 }
+
+// Reset removes all sections, keys and comments and restores options to
+// their defaults, so c is equivalent to a newly created configuration
+// but keeps its allocated maps for reuse.
+func (c *ConfigFile) Reset() {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	c.fileNames = nil
+	c.loadedFiles = nil
+	for section := range c.data {
+		delete(c.data, section)
+	}
+	c.sectionList = c.sectionList[:0]
+	for section := range c.keyList {
+		delete(c.keyList, section)
+	}
+	for section := range c.sectionComments {
+		delete(c.sectionComments, section)
+	}
+	for section := range c.sectionParents {
+		delete(c.sectionParents, section)
+	}
+	for section := range c.keyComments {
+		delete(c.keyComments, section)
+	}
+	for section := range c.keyTypes {
+		delete(c.keyTypes, section)
+	}
+	for section := range c.keyQuotes {
+		delete(c.keyQuotes, section)
+	}
+	c.keyValues = nil
+	c.footerComments = ""
+
+	c.BlockMode = true
+	c.LenientQuotes = false
+	c.DefaultOverrides = false
+	c.TypeAnnotations = false
+	c.KeyValueSpacing = SPACING_SINGLE
+	c.Delimiters = ""
+	c.CommentPrefixes = nil
+	c.InlineComment = false
+	c.LineContinuation = false
+	c.StrictDuplicates = false
+	c.MultiValues = false
+	c.IndentNesting = false
+	c.ExpandEnv = false
+	c.StrictVars = false
+	c.Resolver = nil
+	c.SectionArrays = false
+	c.ShardedLocks = false
+	c.CaseInsensitive = false
+	c.StrictNames = false
+	c.OnReloadError = nil
+	c.reloadHooks = nil
+	c.metricsHook = nil
+	c.fallbackSection = DEFAULT_SECTION
+	c.envPrefix, c.envSeparator = "", ""
+	c.FileRefs = false
+	c.fileRefs = nil
+	c.sectionLocks = nil
+}
+
+// copyOptions copies all options from src to c.
+func (c *ConfigFile) copyOptions(src *ConfigFile) {
+	c.BlockMode = src.BlockMode
+	c.LenientQuotes = src.LenientQuotes
+	c.DefaultOverrides = src.DefaultOverrides
+	c.TypeAnnotations = src.TypeAnnotations
+	c.KeyValueSpacing = src.KeyValueSpacing
+	c.Delimiters = src.Delimiters
+	c.CommentPrefixes = src.CommentPrefixes
+	c.InlineComment = src.InlineComment
+	c.LineContinuation = src.LineContinuation
+	c.StrictDuplicates = src.StrictDuplicates
+	c.MultiValues = src.MultiValues
+	c.IndentNesting = src.IndentNesting
+	c.ExpandEnv = src.ExpandEnv
+	c.StrictVars = src.StrictVars
+	c.Resolver = src.Resolver
+	c.SectionArrays = src.SectionArrays
+	c.ShardedLocks = src.ShardedLocks
+	c.CaseInsensitive = src.CaseInsensitive
+	c.StrictNames = src.StrictNames
+	c.OnReloadError = src.OnReloadError
+	c.FileRefs = src.FileRefs
+	c.fallbackSection = src.fallbackSection
+	c.envPrefix, c.envSeparator = src.envPrefix, src.envSeparator
+}
+
+// Clone returns a deep copy of c with its options, changes to the copy
+// never affect c and vice versa. Hooks set by OnReload and SetMetricsHook
+// are not copied.
+func (c *ConfigFile) Clone() *ConfigFile {
+	c.rlock()
+	defer c.runlock()
+	return c.clone()
+}
+
+// clone returns a deep copy of c, the caller must hold the read lock.
+func (c *ConfigFile) clone() *ConfigFile {
+	cc := newConfigFile(append([]string(nil), c.fileNames...))
+	cc.copyOptions(c)
+	cc.loadedFiles = append([]string(nil), c.loadedFiles...)
+	cc.sectionList = append([]string(nil), c.sectionList...)
+	cc.footerComments = c.footerComments
+	for _, section := range c.sectionList {
+		cc.data[section] = copyMap(c.data[section])
+		cc.keyList[section] = append([]string(nil), c.keyList[section]...)
+		if c.ShardedLocks {
+			if cc.sectionLocks == nil {
+				cc.sectionLocks = make(map[string]*sync.RWMutex)
+			}
+			cc.sectionLocks[section] = new(sync.RWMutex)
+		}
+	}
+	for section, comments := range c.sectionComments {
+		cc.sectionComments[section] = comments
+	}
+	for section, parent := range c.sectionParents {
+		cc.sectionParents[section] = parent
+	}
+	for section, keys := range c.keyComments {
+		cc.keyComments[section] = copyMap(keys)
+	}
+	for section, keys := range c.keyTypes {
+		cc.keyTypes[section] = copyMap(keys)
+	}
+	for section, keys := range c.keyQuotes {
+		cc.keyQuotes[section] = copyMap(keys)
+	}
+	for section, keys := range c.keyValues {
+		for key, values := range keys {
+			cc.addValues(section, key, values)
+		}
+	}
+	return cc
+}
+
+// addValue adds value as a new occurrence of key in the given section,
+// see MultiValues. It is used by the parser for repeated keys.
+func (c *ConfigFile) addValue(section, key, value string) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	section = c.sectionName(section)
+	key = c.keyName(section, key)
+	old, ok := c.data[section][key]
+	if !ok {
+		c.set(section, key, value)
+		return
+	}
+	values, ok := c.keyValues[section][key]
+	if !ok {
+		values = []string{old}
+	}
+	c.data[section][key] = value
+	c.addValues(section, key, append(values, value))
+}
+
+// addValues stores a copy of every occurrence of key in the given section,
+// the caller must hold the write lock.
+func (c *ConfigFile) addValues(section, key string, values []string) {
+	if c.keyValues == nil {
+		c.keyValues = make(map[string]map[string][]string)
+	}
+	if _, ok := c.keyValues[section]; !ok {
+		c.keyValues[section] = make(map[string][]string)
+	}
+	c.keyValues[section][key] = append([]string{}, values...)
+}
+
+// copyMap returns a copy of m.
+func copyMap(m map[string]string) map[string]string {
+	cm := make(map[string]string, len(m))
+	for k, v := range m {
+		cm[k] = v
+	}
+	return cm
+}
+
+// Merge copies all sections, keys and comments of other into c,
+// values of other win for keys exist in both. See MergeFunc.
+func (c *ConfigFile) Merge(other *ConfigFile) {
+	c.MergeFunc(other, nil)
+}
+
+// MergeFunc copies all sections, keys and comments of other into c.
+// For every key exists in both, resolve is called with value of c as a
+// and value of other as b, and its result is used.
+// New sections and keys are appended in the order of other,
+// those with names not allowed by StrictNames are skipped.
+func (c *ConfigFile) MergeFunc(other *ConfigFile, resolve func(section, key, a, b string) string) {
+	if other == c {
+		return
+	}
+
+	// Take a snapshot first, so c and other are never locked together.
+	other.rlock()
+	o := other.clone()
+	other.runlock()
+
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	for _, osection := range o.sectionList {
+		if c.StrictNames && CheckSectionName(osection) != nil {
+			continue
+		}
+		section := c.ensureSection(osection)
+		if comments, ok := o.sectionComments[osection]; ok {
+			c.sectionComments[section] = comments
+		}
+		if parent, ok := o.sectionParents[osection]; ok {
+			c.sectionParents[section] = parent
+		}
+
+		for _, okey := range o.keyList[osection] {
+			if c.StrictNames && CheckKeyName(okey, false) != nil {
+				continue
+			}
+			value := o.data[osection][okey]
+			key := c.keyName(section, okey)
+			old, exists := c.data[section][key]
+			resolved := exists && resolve != nil
+			if resolved {
+				value = resolve(section, key, old, value)
+			}
+			c.set(section, key, value)
+			if !resolved {
+				if quote, ok := o.keyQuotes[osection][okey]; ok {
+					setInner(c.keyQuotes, section, key, quote)
+				}
+				if values, ok := o.keyValues[osection][okey]; ok {
+					c.addValues(section, key, values)
+				}
+			}
+
+			if comments, ok := o.keyComments[osection][okey]; ok {
+				setInner(c.keyComments, section, key, comments)
+			}
+			if typ, ok := o.keyTypes[osection][okey]; ok {
+				setInner(c.keyTypes, section, key, typ)
+			}
+		}
+	}
+}
+
+// Equal reports whether c and other have the same sections and keys
+// with the same values before substitution. Order and comments are ignored.
+func (c *ConfigFile) Equal(other *ConfigFile) bool {
+	if other == c {
+		return true
+	}
+
+	// Take a snapshot first, so c and other are never locked together.
+	other.rlock()
+	o := other.clone()
+	other.runlock()
+
+	c.rlock()
+	defer c.runlock()
+
+	if len(c.data) != len(o.data) {
+		return false
+	}
+	for section, keys := range c.data {
+		okeys, ok := o.data[section]
+		if !ok || len(keys) != len(okeys) {
+			return false
+		}
+		for key, value := range keys {
+			if ovalue, ok := okeys[key]; !ok || value != ovalue {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// setInner sets m[section][key] to value, the inner map is created if needed.
+func setInner(m map[string]map[string]string, section, key, value string) {
+	if _, ok := m[section]; !ok {
+		m[section] = make(map[string]string)
+	}
+	m[section][key] = value
+}
+
+var configPool = sync.Pool{
+	New: func() interface{} {
+		return newConfigFile(nil)
+	},
+}
+
+// GetPooled returns an empty configuration from a shared pool,
+// which avoids map allocations when parsing many small configurations.
+// Return it with PutPooled when it is no longer needed.
+func GetPooled() *ConfigFile {
+	return configPool.Get().(*ConfigFile)
+}
+
+// PutPooled resets c and puts it back to the shared pool.
+// c and anything referencing its content must not be used after this call.
+func PutPooled(c *ConfigFile) {
+	c.Reset()
+	configPool.Put(c)
+}
+
+// LoadedFiles returns names of files which are actually loaded,
+// optional files that do not exist are not included.
+func (c *ConfigFile) LoadedFiles() []string {
+	c.rlock()
+	defer c.runlock()
+
+	return append([]string(nil), c.loadedFiles...)
+}
+
+// ConfigStats holds size statistics of a loaded configuration.
+type ConfigStats struct {
+	Sections   int // Number of sections.
+	Keys       int // Number of keys in all sections.
+	Comments   int // Number of section and key comments.
+	ValueBytes int // Total length of raw values.
+	MaxDepth   int // Deepest sub-section level, e.g. 2 for "a.b.c".
+}
+
+// Stats returns size statistics of the configuration.
+func (c *ConfigFile) Stats() ConfigStats {
+	c.rlock()
+	defer c.runlock()
+
+	var st ConfigStats
+	st.Sections = len(c.sectionList)
+	for _, section := range c.sectionList {
+		if depth := strings.Count(section, "."); depth > st.MaxDepth {
+			st.MaxDepth = depth
+		}
+		for _, key := range c.keyList[section] {
+			st.Keys++
+			st.ValueBytes += len(c.data[section][key])
+		}
+	}
+
+	st.Comments = len(c.sectionComments)
+	for _, comments := range c.keyComments {
+		st.Comments += len(comments)
+	}
+	return st
+}
+
+// Validate checks that every key listed for each section of required exists,
+// honoring fallback as GetValue does. A key may declare the expected type
+// in the TypeAnnotations syntax, e.g. "port<int>", and its value must parse
+// as that type, one of string, bool, int, int64, uint, float64 and duration.
+// It returns an error listing every problem found, or nil if there is none.
+func (c *ConfigFile) Validate(required map[string][]string) error {
+	sections := make([]string, 0, len(required))
+	for section := range required {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	var problems []string
+	for _, section := range sections {
+		for _, key := range required[section] {
+			var typ string
+			if i := strings.LastIndex(key, "<"); i > 0 && strings.HasSuffix(key, ">") {
+				key, typ = key[:i], key[i+1:len(key)-1]
+			}
+
+			value, err := c.getValue(section, key)
+			if errors.Is(err, ErrSectionNotFound) {
+				problems = append(problems, fmt.Sprintf("[%s]: %v", section, err))
+				break
+			}
+			if err == nil {
+				err = checkType(typ, value)
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("[%s] %s: %v", section, key, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkType returns an error if value does not parse as type typ,
+// see Validate.
+func checkType(typ, value string) (err error) {
+	switch typ {
+	case "", "string":
+	case "bool":
+		_, err = parseBool(value)
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "int64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "uint":
+		_, err = strconv.ParseUint(value, 10, 0)
+	case "float64":
+		_, err = strconv.ParseFloat(value, 64)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	default:
+		err = fmt.Errorf("unknown type '%s'", typ)
+	}
+	return err
+}
+
+// NormalizeOptions controls what Normalize rewrites.
+type NormalizeOptions struct {
+	SortSections bool   // Sort sections by name.
+	SortKeys     bool   // Sort keys by name in every section.
+	CommentChar  string // Use this prefix for all comment lines if not empty, e.g. "#".
+	TrimValues   bool   // Trim leading and trailing spaces of values which are not quoted.
+}
+
+// Normalize rewrites order and formatting of the configuration in place,
+// so the next save produces canonical output.
+func (c *ConfigFile) Normalize(opts NormalizeOptions) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	if opts.SortSections {
+		sort.Strings(c.sectionList)
+	}
+	if opts.SortKeys {
+		for _, keys := range c.keyList {
+			sort.Strings(keys)
+		}
+	}
+
+	if len(opts.CommentChar) > 0 {
+		for section, comments := range c.sectionComments {
+			c.sectionComments[section] = c.replaceCommentChar(comments, opts.CommentChar)
+		}
+		for _, keys := range c.keyComments {
+			for key, comments := range keys {
+				keys[key] = c.replaceCommentChar(comments, opts.CommentChar)
+			}
+		}
+		c.footerComments = c.replaceCommentChar(c.footerComments, opts.CommentChar)
+	}
+
+	if opts.TrimValues {
+		// Quoted values keep their spaces.
+		for section, keys := range c.data {
+			for key, value := range keys {
+				if len(c.keyQuotes[section][key]) == 0 {
+					keys[key] = strings.TrimSpace(value)
+				}
+			}
+		}
+		for section, keys := range c.keyValues {
+			for key, values := range keys {
+				if len(c.keyQuotes[section][key]) > 0 {
+					continue
+				}
+				for i, value := range values {
+					values[i] = strings.TrimSpace(value)
+				}
+			}
+		}
+	}
+}
+
+// replaceCommentChar replaces the leading comment prefix of every line in comments.
+func (c *ConfigFile) replaceCommentChar(comments, char string) string {
+	lines := strings.Split(comments, LineBreak)
+	for i, line := range lines {
+		if prefix := c.commentPrefix(line); len(prefix) > 0 {
+			lines[i] = char + line[len(prefix):]
+		}
+	}
+	return strings.Join(lines, LineBreak)
+}
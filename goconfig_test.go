@@ -1,10 +1,28 @@
 package goconfig
 
 import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// loadDefault loads conf/app.conf as the default configuration.
+func loadDefault(t *testing.T) {
+	c, err := LoadConfigFile("conf/app.conf")
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	SetDefault(c)
+}
+
 func Test_Goconfig(t *testing.T) {
+	loadDefault(t)
 	xxx := MustValue("", "xxx", "")
 	t.Log(xxx)
 	name := MustValue("app", "name", "default")
@@ -23,3 +41,1353 @@ func Test_Goconfig(t *testing.T) {
 	t.Log(b_c)
 	t.Log(l_d)
 }
+
+func Test_SetMany(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "old")
+
+	inserted, overwritten := c.SetMany([]Entry{
+		{"app", "name", "new"},
+		{"app", "version", "1.0"},
+		{"", "xxx", "yyy"},
+		{"app", "", "skipped"},
+	})
+	if inserted != 2 || overwritten != 1 {
+		t.Fatalf("SetMany: expect 2 inserted and 1 overwritten, got %d and %d", inserted, overwritten)
+	}
+	if v, _ := c.getValue("app", "name"); v != "new" {
+		t.Errorf("app.name: expect 'new', got '%s'", v)
+	}
+	if v, _ := c.getValue(DEFAULT_SECTION, "xxx"); v != "yyy" {
+		t.Errorf("DEFAULT.xxx: expect 'yyy', got '%s'", v)
+	}
+}
+
+func Test_SetValues(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "port", "80")
+
+	c.SetValues("app", map[string]string{"port": "8080", "name": "abc", "debug": "true"})
+	c.SetValues("", map[string]string{"host": "localhost"})
+	if out := saveString(t, c); out != "host = localhost\n\n[app]\nport = 8080\ndebug = true\nname = abc\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+}
+
+func Test_GetValueOrDefault(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "")
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "debug", "yes please")
+
+	if v := c.GetValueOrDefault("app", "name", "def"); v != "" {
+		t.Errorf("app.name: expect empty value, got '%s'", v)
+	}
+	if v := c.GetValueOrDefault("app", "missing", "def"); v != "def" {
+		t.Errorf("app.missing: expect 'def', got '%s'", v)
+	}
+	if v := c.GetValueOrDefault("nosection", "name", "def"); v != "def" {
+		t.Errorf("nosection.name: expect 'def', got '%s'", v)
+	}
+	if v, found := c.GetValueWithDefault("app", "name", "def"); v != "" || !found {
+		t.Errorf("GetValueWithDefault(app.name): expect empty value found, got '%s' %v", v, found)
+	}
+	if v, found := c.GetValueWithDefault("app", "missing", "def"); v != "def" || found {
+		t.Errorf("GetValueWithDefault(app.missing): expect 'def' not found, got '%s' %v", v, found)
+	}
+	if v, found := c.GetValueWithDefault("nosection", "name", "def"); v != "def" || found {
+		t.Errorf("GetValueWithDefault(nosection.name): expect 'def' not found, got '%s' %v", v, found)
+	}
+	if v := c.GetIntOrDefault("app", "port", 80); v != 8080 {
+		t.Errorf("app.port: expect 8080, got %d", v)
+	}
+	if v := c.GetBoolOrDefault("app", "debug", true); v != true {
+		t.Errorf("app.debug: expect default true, got %v", v)
+	}
+	if v := c.GetFloat64OrDefault("nosection", "ratio", 0.5); v != 0.5 {
+		t.Errorf("nosection.ratio: expect 0.5, got %v", v)
+	}
+}
+
+func Test_DefaultOverrides(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "global")
+	c.SetValue("app", "host", "local")
+	c.SetValue("app", "port", "8080")
+
+	if v, _ := c.getValue("app", "host"); v != "local" {
+		t.Errorf("app.host: expect 'local', got '%s'", v)
+	}
+
+	c.DefaultOverrides = true
+	if v, _ := c.getValue("app", "host"); v != "global" {
+		t.Errorf("app.host with DefaultOverrides: expect 'global', got '%s'", v)
+	}
+	if v, _ := c.getValue("app", "port"); v != "8080" {
+		t.Errorf("app.port with DefaultOverrides: expect '8080', got '%s'", v)
+	}
+
+	// Occurrences come from DEFAULT section too.
+	c = newConfigFile(nil)
+	c.MultiValues = true
+	if err := c.read(strings.NewReader("host = global\n[app]\nhost = a\nhost = b\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	c.DefaultOverrides = true
+	if values, err := c.GetValues("app", "host"); err != nil || len(values) != 1 || values[0] != "global" {
+		t.Errorf("GetValues with DefaultOverrides: expect [global], got %v (%v)", values, err)
+	}
+}
+
+func Test_Pooled(t *testing.T) {
+	c := GetPooled()
+	c.LenientQuotes = true
+	c.SetValue("app", "name", "pooled")
+	c.SetSectionComments("app", "comments")
+	PutPooled(c)
+
+	c = GetPooled()
+	defer PutPooled(c)
+	if len(c.data) != 0 || len(c.sectionList) != 0 || len(c.sectionComments) != 0 {
+		t.Error("GetPooled: expect empty configuration")
+	}
+	if c.LenientQuotes || !c.BlockMode {
+		t.Error("GetPooled: expect default options")
+	}
+}
+
+const benchConf = `[app]
+name = bench
+version = 1.0
+
+[database]
+host = 127.0.0.1:3306
+user = root
+`
+
+func BenchmarkParseFresh(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := newConfigFile(nil)
+		c.read(strings.NewReader(benchConf))
+	}
+}
+
+func BenchmarkParsePooled(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		c := GetPooled()
+		c.read(strings.NewReader(benchConf))
+		PutPooled(c)
+	}
+}
+
+func Test_GetValueAt(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "server", "a")
+
+	for _, i := range []int{0, -1} {
+		if v, err := c.GetValueAt("app", "server", i); err != nil || v != "a" {
+			t.Errorf("GetValueAt(%d): expect 'a', got '%s' (%v)", i, v, err)
+		}
+	}
+	if _, err := c.GetValueAt("app", "server", 1); err == nil {
+		t.Error("GetValueAt(1): expect out of range error")
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader(`; app comments
+[app]
+name = abc
+[app.db.master]
+; key comments
+host = 1.2.3.4
+[empty]
+`))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	st := c.Stats()
+	expect := ConfigStats{Sections: 3, Keys: 2, Comments: 2, ValueBytes: 10, MaxDepth: 2}
+	if st != expect {
+		t.Errorf("Stats: expect %+v, got %+v", expect, st)
+	}
+}
+
+func Test_ExplainValue(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "url", "http://%(host)s:%(port)s")
+
+	expect := "[app] url = http://%(host)s:%(port)s" + LineBreak +
+		"-> http://localhost:8080"
+	if trace := c.ExplainValue("app", "url"); trace != expect {
+		t.Errorf("ExplainValue: expect\n%s\ngot\n%s", expect, trace)
+	}
+	if trace := c.ExplainValue("app", "missing"); trace != "[app] missing: key 'missing' not found" {
+		t.Errorf("ExplainValue: unexpected trace for missing key: %s", trace)
+	}
+}
+
+func Test_GetJSON(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("[app]\nhosts = [\"a\", \"b\"]\nports = [80, 443]\nbad = [1,\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	hosts, err := c.GetJSONStrings("app", "hosts")
+	if err != nil || len(hosts) != 2 || hosts[0] != "a" || hosts[1] != "b" {
+		t.Errorf("GetJSONStrings: expect [a b], got %v (%v)", hosts, err)
+	}
+	ports, err := c.GetJSONInts("app", "ports")
+	if err != nil || len(ports) != 2 || ports[0] != 80 || ports[1] != 443 {
+		t.Errorf("GetJSONInts: expect [80 443], got %v (%v)", ports, err)
+	}
+	if _, err = c.GetJSONInts("app", "bad"); err == nil {
+		t.Error("GetJSONInts: expect error for malformed value")
+	}
+}
+
+func Test_ReferencesOf(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "url", "http://%(host)s/")
+	c.SetValue("app", "name", "host")
+	c.SetValue("db", "addr", "%(host)s:3306")
+
+	refs := c.ReferencesOf("host")
+	if len(refs) != 2 || refs[0] != (Entry{"app", "url", "http://%(host)s/"}) ||
+		refs[1] != (Entry{"db", "addr", "%(host)s:3306"}) {
+		t.Errorf("ReferencesOf: unexpected result %v", refs)
+	}
+}
+
+func Test_GetTriBool(t *testing.T) {
+	c := newConfigFile(nil)
+	for value, expect := range map[string]int{"true": 1, "0": 0, "Auto": -1, "default": -1, "unset": -1} {
+		c.SetValue("app", "color", value)
+		if state, err := c.GetTriBool("app", "color"); err != nil || state != expect {
+			t.Errorf("GetTriBool(%s): expect %d, got %d (%v)", value, expect, state, err)
+		}
+	}
+
+	c.SetValue("app", "color", "sometimes")
+	if _, err := c.GetTriBool("app", "color"); err == nil {
+		t.Error("GetTriBool: expect error for unrecognized value")
+	}
+}
+
+func Test_GetValue(t *testing.T) {
+	c, err := LoadConfigFile("conf/app.conf")
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	c.SetValue("app", "name", "changed")
+	loadDefault(t)
+
+	if v, err := c.GetValue("app", "name"); err != nil || v != "changed" {
+		t.Errorf("app.name: expect 'changed', got '%s' (%v)", v, err)
+	}
+	// Global configuration is not touched.
+	if v, _ := Value("app", "name"); v != "123" {
+		t.Errorf("global app.name: expect '123', got '%s'", v)
+	}
+	if _, err := c.GetValue("nosection", "name"); err == nil {
+		t.Error("GetValue: expect error for missing section")
+	}
+}
+
+func Test_TypedGetters(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("test", "b", "true")
+	c.SetValue("test", "f", "1.5")
+	c.SetValue("test", "i", "-3")
+	c.SetValue("test", "l", "9000000000")
+
+	if v, err := c.Bool("test", "b"); err != nil || !v {
+		t.Errorf("Bool: expect true, got %v (%v)", v, err)
+	}
+	if v, err := c.Float64("test", "f"); err != nil || v != 1.5 {
+		t.Errorf("Float64: expect 1.5, got %v (%v)", v, err)
+	}
+	if v, err := c.Int("test", "i"); err != nil || v != -3 {
+		t.Errorf("Int: expect -3, got %v (%v)", v, err)
+	}
+	if v, err := c.Int64("test", "l"); err != nil || v != 9000000000 {
+		t.Errorf("Int64: expect 9000000000, got %v (%v)", v, err)
+	}
+	if v, err := c.Uint("test", "l"); err != nil || v != 9000000000 {
+		t.Errorf("Uint: expect 9000000000, got %v (%v)", v, err)
+	}
+	if v, err := c.Uint64("test", "l"); err != nil || v != 9000000000 {
+		t.Errorf("Uint64: expect 9000000000, got %v (%v)", v, err)
+	}
+	if _, err := c.Uint64("test", "i"); err == nil {
+		t.Error("Uint64: expect error for negative value")
+	}
+	if _, err := c.Int("test", "b"); err == nil {
+		t.Error("Int: expect error for non-integer value")
+	}
+}
+
+func Test_IntInRange(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "workers", "100")
+	c.SetValue("app", "name", "abc")
+
+	if v, err := c.GetIntInRange("app", "port", 1, 65535); err != nil || v != 8080 {
+		t.Errorf("GetIntInRange(port): expect 8080, got %d (%v)", v, err)
+	}
+	if _, err := c.GetIntInRange("app", "workers", 1, 64); err == nil || err.Error() != "value 100 of key 'workers' out of range [1, 64]" {
+		t.Errorf("GetIntInRange(workers): expect out of range error, got %v", err)
+	}
+	if _, err := c.GetIntInRange("app", "name", 1, 64); err == nil {
+		t.Error("GetIntInRange(name): expect error for non-integer value")
+	}
+
+	tests := []struct {
+		key        string
+		max        int
+		defaultVal []int
+		expect     int
+	}{
+		{"port", 65535, nil, 8080},
+		{"workers", 64, []int{8}, 64},
+		{"port", 64, nil, 64},
+		{"name", 64, []int{8}, 8},
+		{"name", 64, nil, 1},
+		{"missing", 64, nil, 1},
+	}
+	for _, test := range tests {
+		if v := c.MustIntInRange("app", test.key, 1, test.max, test.defaultVal...); v != test.expect {
+			t.Errorf("MustIntInRange(%s, 1, %d, %v): expect %d, got %d", test.key, test.max, test.defaultVal, test.expect, v)
+		}
+	}
+}
+
+func Test_SetEnvOverride(t *testing.T) {
+	t.Setenv("MYAPP_DB_MASTER_MAX_CONNS", "100")
+	t.Setenv("MYAPP_HOST", "example.com")
+	t.Setenv("MYAPP__APP__NAME", "")
+	t.Setenv("MYAPP_APP_PORT", "8080")
+
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("db.master", "max-conns", "10")
+	c.SetValue("app", "name", "abc")
+	c.SetValue("app", "url", "http://%(host)s/")
+
+	if v := c.MustValue("db.master", "max-conns"); v != "10" {
+		t.Errorf("db.master.max-conns: expect '10' by default, got '%s'", v)
+	}
+
+	c.SetEnvOverride("MYAPP")
+	tests := []struct{ section, key, expect string }{
+		{"db.master", "max-conns", "100"},
+		{"", "host", "example.com"},
+		{"app", "name", "abc"},
+		{"app", "port", "8080"},
+		// Variables are not overridden.
+		{"app", "url", "http://localhost/"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s' (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+
+	c.SetEnvOverride("MYAPP", "__")
+	if v, err := c.GetValue("app", "name"); err != nil || v != "" {
+		t.Errorf("app.name: expect empty value from environment, got '%s' (%v)", v, err)
+	}
+
+	c.SetEnvOverride("")
+	if v := c.MustValue("", "host"); v != "localhost" {
+		t.Errorf("DEFAULT.host: expect 'localhost' when disabled, got '%s'", v)
+	}
+
+	// Every getter sees the override.
+	c = newConfigFile(nil)
+	c.MultiValues = true
+	if err := c.read(strings.NewReader("[app]\nport = `80`\nport = 81\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	c.SetEnvOverride("MYAPP")
+	if values, err := c.GetValues("app", "port"); err != nil || len(values) != 1 || values[0] != "8080" {
+		t.Errorf("GetValues: expect [8080], got %v (%v)", values, err)
+	}
+	if v, err := c.GetValueAt("app", "port", -1); err != nil || v != "8080" {
+		t.Errorf("GetValueAt: expect '8080', got '%s' (%v)", v, err)
+	}
+	if v, err := c.GetValueOnce("app", "port"); err != nil || v != "8080" {
+		t.Errorf("GetValueOnce: expect '8080', got '%s' (%v)", v, err)
+	}
+	if v, err := c.GetValueRawQuotes("app", "port"); err != nil || v != "8080" {
+		t.Errorf("GetValueRawQuotes: expect '8080', got '%s' (%v)", v, err)
+	}
+}
+
+func Test_ExpandEnv(t *testing.T) {
+	t.Setenv("GOCONFIG_HOST", "example.com")
+	t.Setenv("GOCONFIG_PORT", "8080")
+
+	c := newConfigFile(nil)
+	c.SetValue("app", "url", "https://${GOCONFIG_HOST}:${GOCONFIG_PORT}/api${GOCONFIG_UNDEFINED}")
+
+	if v, _ := c.getValue("app", "url"); v != "https://${GOCONFIG_HOST}:${GOCONFIG_PORT}/api${GOCONFIG_UNDEFINED}" {
+		t.Errorf("app.url: expect no expansion by default, got '%s'", v)
+	}
+
+	c.ExpandEnv = true
+	if v, _ := c.getValue("app", "url"); v != "https://example.com:8080/api" {
+		t.Errorf("app.url: expect 'https://example.com:8080/api', got '%s'", v)
+	}
+
+	c.StrictVars = true
+	if _, err := c.getValue("app", "url"); err == nil {
+		t.Error("app.url: expect error for undefined variable with StrictVars")
+	}
+}
+
+func Test_MustMethods(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "empty", "")
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "negative", "-1")
+
+	if v := c.MustValue("app", "empty", "def"); v != "def" {
+		t.Errorf("MustValue(empty): expect 'def', got '%s'", v)
+	}
+	if v := c.MustValue("app", "missing"); v != "" {
+		t.Errorf("MustValue(missing): expect empty value, got '%s'", v)
+	}
+	if v := c.MustInt("app", "port", 80); v != 8080 {
+		t.Errorf("MustInt: expect 8080, got %d", v)
+	}
+	if v := c.MustInt64("app", "missing", 1); v != 1 {
+		t.Errorf("MustInt64: expect 1, got %d", v)
+	}
+	if v := c.MustUint("app", "port", 1); v != 8080 {
+		t.Errorf("MustUint: expect 8080, got %d", v)
+	}
+	if v := c.MustUint64("app", "negative", 1); v != 1 {
+		t.Errorf("MustUint64: expect 1, got %d", v)
+	}
+	if v := c.MustUint64("app", "negative"); v != 0 {
+		t.Errorf("MustUint64: expect 0, got %d", v)
+	}
+	if v := c.MustBool("app", "port", true); v != true {
+		t.Errorf("MustBool: expect default true, got %v", v)
+	}
+	if v := c.MustFloat64("app", "port"); v != 8080 {
+		t.Errorf("MustFloat64: expect 8080, got %v", v)
+	}
+}
+
+func Test_ShardedLocks(t *testing.T) {
+	c := newConfigFile(nil)
+	c.ShardedLocks = true
+	for _, section := range []string{"a", "b", "c"} {
+		c.SetValue(section, "key", "0")
+	}
+
+	var wg sync.WaitGroup
+	for _, section := range []string{"a", "b", "c"} {
+		wg.Add(2)
+		go func(section string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.SetValue(section, "key", strconv.Itoa(i))
+				c.SetValue(section, "new"+strconv.Itoa(i%10), "x")
+			}
+		}(section)
+		go func(section string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.getValue(section, "key")
+				c.Stats()
+			}
+		}(section)
+	}
+	wg.Wait()
+
+	if v, _ := c.getValue("b", "key"); v != "99" {
+		t.Errorf("b.key: expect '99', got '%s'", v)
+	}
+	if st := c.Stats(); st.Keys != 33 {
+		t.Errorf("Stats: expect 33 keys, got %d", st.Keys)
+	}
+}
+
+func benchmarkDisjointWrites(b *testing.B, sharded bool) {
+	c := newConfigFile(nil)
+	c.ShardedLocks = sharded
+	for i := 0; i < 64; i++ {
+		c.SetValue("section"+strconv.Itoa(i), "key", "0")
+	}
+
+	var n int32
+	b.RunParallel(func(pb *testing.PB) {
+		section := "section" + strconv.Itoa(int(atomic.AddInt32(&n, 1))%64)
+		for pb.Next() {
+			c.SetValue(section, "key", "1")
+		}
+	})
+}
+
+func BenchmarkWritesSingleLock(b *testing.B) { benchmarkDisjointWrites(b, false) }
+
+func BenchmarkWritesShardedLocks(b *testing.B) { benchmarkDisjointWrites(b, true) }
+
+func Test_Resolved(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("host = localhost\n[app]\nurl = http://%(host)s/\n[empty]\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	m := c.Resolved()
+	if len(m) != 3 || m["app"]["url"] != "http://localhost/" || m[DEFAULT_SECTION]["host"] != "localhost" {
+		t.Errorf("Resolved: unexpected result %v", m)
+	}
+	if len(m["empty"]) != 0 {
+		t.Errorf("Resolved: expect no key in empty section, got %v", m["empty"])
+	}
+
+	// Snapshot is independent of configuration.
+	m["app"]["url"] = "changed"
+	if v, _ := c.GetValue("app", "url"); v != "http://localhost/" {
+		t.Errorf("app.url: expect 'http://localhost/', got '%s'", v)
+	}
+}
+
+func Test_Delete(t *testing.T) {
+	c := newConfigFile(nil)
+	if !c.SetValue("app", "name", "abc") || c.SetValue("app", "name", "def") {
+		t.Error("SetValue: expect true on insert and false on overwrite")
+	}
+	c.SetValue("app", "version", "1.0")
+	c.SetValue("db", "host", "localhost")
+	c.SetKeyComments("app", "name", "name comments")
+	c.SetSectionComments("db", "db comments")
+
+	if !c.DeleteKey("app", "name") || c.DeleteKey("app", "name") {
+		t.Error("DeleteKey: expect true then false")
+	}
+	if _, err := c.GetValue("app", "name"); err == nil {
+		t.Error("app.name: expect error after DeleteKey")
+	}
+	if len(c.keyList["app"]) != 1 || len(c.keyComments["app"]) != 0 {
+		t.Errorf("DeleteKey: expect key list and comments cleaned, got %v and %v", c.keyList["app"], c.keyComments["app"])
+	}
+
+	if !c.DeleteSection("db") || c.DeleteSection("db") {
+		t.Error("DeleteSection: expect true then false")
+	}
+	if len(c.sectionList) != 1 || c.sectionList[0] != "app" || len(c.sectionComments) != 0 {
+		t.Errorf("DeleteSection: expect only section 'app' left, got %v", c.sectionList)
+	}
+}
+
+func Test_GetValueContext(t *testing.T) {
+	c := newConfigFile(nil)
+	c.ExpandEnv = true
+	c.Resolver = func(ctx context.Context, name string) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return "remote-" + name, nil
+		}
+	}
+	c.SetValue("app", "secret", "${TOKEN}")
+
+	if v, err := c.GetValueContext(context.Background(), "app", "secret"); err != nil || v != "remote-TOKEN" {
+		t.Errorf("app.secret: expect 'remote-TOKEN', got '%s' (%v)", v, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := c.GetValueContext(ctx, "app", "secret"); err != context.DeadlineExceeded {
+		t.Errorf("app.secret: expect context.DeadlineExceeded, got %v", err)
+	}
+
+	// Error of a variable in DEFAULT section is not taken as undefined.
+	boom := errors.New("boom")
+	c.Resolver = func(ctx context.Context, name string) (string, error) {
+		return "", boom
+	}
+	c.SetValue(DEFAULT_SECTION, "secret", "${S}")
+	c.SetValue("db", "dsn", "u:%(secret)s")
+	if v, err := c.GetValue("db", "dsn"); err != boom {
+		t.Errorf("db.dsn: expect error 'boom', got '%s' (%v)", v, err)
+	}
+}
+
+func Test_NoDefault(t *testing.T) {
+	defer SetDefault(cf)
+	SetDefault(nil)
+
+	if _, err := Value("app", "name"); err != errNoConfig {
+		t.Errorf("Value: expect errNoConfig, got %v", err)
+	}
+	if _, err := Int("test", "i_a"); err != errNoConfig {
+		t.Errorf("Int: expect errNoConfig, got %v", err)
+	}
+	if v := MustValue("app", "name", "default"); v != "default" {
+		t.Errorf("MustValue: expect 'default', got '%s'", v)
+	}
+	if v := MustBool("test", "b_c", true); !v {
+		t.Error("MustBool: expect true")
+	}
+	if v := MustInt64("test", "l_d"); v != 0 {
+		t.Errorf("MustInt64: expect 0, got %d", v)
+	}
+}
+
+func Test_GetSection(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("host = localhost\n[app]\nurl = http://%(host)s/\nname = abc\n[empty]\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	m, err := c.GetSection("app")
+	if err != nil || len(m) != 2 || m["url"] != "http://localhost/" || m["name"] != "abc" {
+		t.Errorf("GetSection(app): unexpected result %v (%v)", m, err)
+	}
+	if m, err = c.GetSection(""); err != nil || m["host"] != "localhost" {
+		t.Errorf("GetSection(DEFAULT): unexpected result %v (%v)", m, err)
+	}
+	if m, err = c.GetSection("empty"); err != nil || len(m) != 0 {
+		t.Errorf("GetSection(empty): expect empty map, got %v (%v)", m, err)
+	}
+	if _, err = c.GetSection("missing"); err == nil {
+		t.Error("GetSection(missing): expect error")
+	}
+}
+
+func Test_MergeFunc(t *testing.T) {
+	a := newConfigFile(nil)
+	a.SetValue("app", "plugins", "auth")
+	a.SetValue("app", "name", "a")
+
+	b := newConfigFile(nil)
+	b.SetValue("app", "plugins", "cache")
+	b.SetValue("app", "port", "80")
+	b.SetValue("db", "host", "localhost")
+	b.SetKeyComments("db", "host", "host comments")
+
+	a.MergeFunc(b, func(section, key, x, y string) string {
+		return x + "," + y
+	})
+
+	for _, v := range []struct{ section, key, value string }{
+		{"app", "plugins", "auth,cache"},
+		{"app", "name", "a"},
+		{"app", "port", "80"},
+		{"db", "host", "localhost"},
+	} {
+		if value, _ := a.GetValue(v.section, v.key); value != v.value {
+			t.Errorf("%s.%s: expect '%s', got '%s'", v.section, v.key, v.value, value)
+		}
+	}
+	if keys := a.keyList["app"]; len(keys) != 3 || keys[2] != "port" {
+		t.Errorf("keyList[app]: expect [plugins name port], got %v", keys)
+	}
+	if a.keyComments["db"]["host"] != "; host comments" {
+		t.Errorf("db.host comments: expect '; host comments', got '%s'", a.keyComments["db"]["host"])
+	}
+
+	// Other is not changed.
+	if value, _ := b.GetValue("app", "plugins"); value != "cache" {
+		t.Errorf("other app.plugins: expect 'cache', got '%s'", value)
+	}
+}
+
+func Test_ExpandEnvSubstitution(t *testing.T) {
+	t.Setenv("GOCONFIG_URL", "http://%(host)s/")
+	t.Setenv("GOCONFIG_LOOP", "x${GOCONFIG_LOOP}")
+
+	c := newConfigFile(nil)
+	c.ExpandEnv = true
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "url", "${GOCONFIG_URL}")
+	c.SetValue("app", "loop", "${GOCONFIG_LOOP}")
+
+	if v, _ := c.GetValue("app", "url"); v != "http://localhost/" {
+		t.Errorf("app.url: expect 'http://localhost/', got '%s'", v)
+	}
+	// Self reference stops at depth limit.
+	expect := strings.Repeat("x", _DEPTH_VALUES) + "${GOCONFIG_LOOP}"
+	if v, _ := c.GetValue("app", "loop"); v != expect {
+		t.Errorf("app.loop: expect '%s', got '%s'", expect, v)
+	}
+}
+
+func Test_Duration(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "timeout", "1m30s")
+	c.SetValue("app", "bad", "5 parsecs")
+
+	if v, err := c.Duration("app", "timeout"); err != nil || v != 90*time.Second {
+		t.Errorf("Duration: expect 1m30s, got %v (%v)", v, err)
+	}
+	if _, err := c.Duration("app", "bad"); err == nil {
+		t.Error("Duration: expect parse error")
+	}
+	if v := c.MustDuration("app", "bad", time.Second); v != time.Second {
+		t.Errorf("MustDuration: expect 1s, got %v", v)
+	}
+
+	defer SetDefault(cf)
+	SetDefault(c)
+	if v := MustDuration("app", "timeout"); v != 90*time.Second {
+		t.Errorf("MustDuration: expect 1m30s, got %v", v)
+	}
+}
+
+func Test_OrphanedComments(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+	c.SetKeyComments("app", "name", "name comments")
+	c.SetKeyComments("app", "missing", "missing comments")
+	c.SetSectionComments("gone", "gone comments")
+
+	c.DeleteKey("app", "name")
+	orphans := c.OrphanedComments()
+	if len(orphans) != 2 || orphans[0] != "[app] missing" || orphans[1] != "[gone]" {
+		t.Errorf("OrphanedComments: expect [[app] missing [gone]], got %v", orphans)
+	}
+}
+
+func Test_GetLists(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "hosts", "a.com, b.com ,c.com,")
+	c.SetValue("app", "ports", "80|443")
+	c.SetValue("app", "ratios", "0.5, x")
+
+	hosts := c.GetStrings("app", "hosts", ",")
+	if len(hosts) != 3 || hosts[0] != "a.com" || hosts[1] != "b.com" || hosts[2] != "c.com" {
+		t.Errorf("GetStrings: expect [a.com b.com c.com], got %v", hosts)
+	}
+	if missing := c.GetStrings("app", "missing", ","); missing == nil || len(missing) != 0 {
+		t.Errorf("GetStrings: expect empty slice for missing key, got %#v", missing)
+	}
+	if ports, err := c.GetInts("app", "ports", "|"); err != nil || len(ports) != 2 || ports[1] != 443 {
+		t.Errorf("GetInts: expect [80 443], got %v (%v)", ports, err)
+	}
+	if _, err := c.GetFloat64s("app", "ratios", ","); err == nil {
+		t.Error("GetFloat64s: expect error for malformed element")
+	}
+}
+
+func Test_GetValueOnce(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue(DEFAULT_SECTION, "pattern", "%(host)s/%(path)s")
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "url", "http://%(host)s:%(port)s")
+	c.SetValue("app", "route", "%(pattern)s")
+
+	if v, _ := c.GetValueOnce("app", "url"); v != "http://localhost:8080" {
+		t.Errorf("url: expect 'http://localhost:8080', got '%s'", v)
+	}
+	// A single pass keeps references from the substituted value.
+	if v, _ := c.GetValueOnce("app", "route"); v != "%(host)s/%(path)s" {
+		t.Errorf("GetValueOnce(route): expect '%%(host)s/%%(path)s', got '%s'", v)
+	}
+	if v, _ := c.GetValue("app", "route"); v != "localhost/" {
+		t.Errorf("GetValue(route): expect 'localhost/', got '%s'", v)
+	}
+	if _, err := c.GetValueOnce("app", "missing"); err == nil {
+		t.Error("GetValueOnce(missing): expect error")
+	}
+}
+
+func Test_GetNamespaced(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "db.port", "3306")
+	c.SetValue(DEFAULT_SECTION, "db.user", "root")
+	c.SetValue("app", "db.host", "10.0.0.1")
+	c.SetValue("app", "host", "localhost")
+	c.SetValue("app", "user", "admin")
+	c.SetValue("app", "name", "abc")
+
+	tests := []struct{ key, expect string }{
+		{"host", "10.0.0.1"},
+		{"port", "3306"},
+		{"user", "root"},
+		{"name", "abc"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetNamespaced("app", "db", test.key); err != nil || v != test.expect {
+			t.Errorf("%s: expect '%s', got '%s' (%v)", test.key, test.expect, v, err)
+		}
+	}
+	if _, err := c.GetNamespaced("app", "db", "missing"); err == nil || err.(getError).Reason != ERR_KEY_NOT_FOUND {
+		t.Errorf("missing: expect key not found, got %v", err)
+	}
+}
+
+func Test_Order(t *testing.T) {
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader("[app]\nname = abc\nversion = 1.0\n[empty]\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	order := c.Order()
+	if keys := order["app"]; len(keys) != 2 || keys[0] != "name" || keys[1] != "version" {
+		t.Errorf("app: expect [name version], got %v", keys)
+	}
+	if keys, ok := order["empty"]; !ok || len(keys) != 0 {
+		t.Errorf("empty: expect [], got %v", keys)
+	}
+
+	// Changing the result does not change c.
+	order["app"][0] = "changed"
+	if c.keyList["app"][0] != "name" {
+		t.Errorf("keyList: expect 'name' first, got %v", c.keyList["app"])
+	}
+}
+
+func Test_Range(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "name", "abc")
+	c.SetValue("app", "url", "http://%(host)s")
+
+	var got []string
+	c.Range(func(section, key, value string) bool {
+		got = append(got, section+"."+key+"="+value)
+		// Setters can be called in fn.
+		c.SetValue("app", "seen", key)
+		return true
+	})
+	expect := []string{"DEFAULT.host=localhost", "app.name=abc", "app.url=http://localhost"}
+	if strings.Join(got, ",") != strings.Join(expect, ",") {
+		t.Errorf("Range: expect %v, got %v", expect, got)
+	}
+
+	// Stop early.
+	n := 0
+	c.Range(func(section, key, value string) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Range: expect 1 call, got %d", n)
+	}
+}
+
+func Test_FileRefs(t *testing.T) {
+	dir := t.TempDir()
+	secret := writeFile(t, dir, "db_pass", "s3cret\n")
+
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "secret_dir", dir)
+	c.SetValue("db", "password", FILE_REF_PREFIX+"%(secret_dir)s/db_pass")
+	c.SetValue("db", "missing", FILE_REF_PREFIX+"%(secret_dir)s/missing")
+
+	if v, _ := c.GetValue("db", "password"); v != FILE_REF_PREFIX+secret {
+		t.Errorf("db.password: expect '%s' without FileRefs, got '%s'", FILE_REF_PREFIX+secret, v)
+	}
+
+	c.FileRefs = true
+	if v, err := c.GetValue("db", "password"); err != nil || v != "s3cret" {
+		t.Errorf("db.password: expect 's3cret', got '%s' (%v)", v, err)
+	}
+	if _, err := c.GetValue("db", "missing"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("db.missing: expect error naming the file, got %v", err)
+	}
+
+	// Contents are cached until cleared.
+	writeFile(t, dir, "db_pass", "changed\r\n")
+	if v, _ := c.GetValue("db", "password"); v != "s3cret" {
+		t.Errorf("db.password: expect cached 's3cret', got '%s'", v)
+	}
+	c.ClearFileRefs()
+	if v, _ := c.GetValue("db", "password"); v != "changed" {
+		t.Errorf("db.password: expect 'changed', got '%s'", v)
+	}
+}
+
+func Test_EnabledFlags(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("alpha = true\nbeta = true\ndelta = 1\n" +
+		"[features]\ngamma = false\nbeta = false\nepsilon = on\nzeta = TRUE\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if flags := strings.Join(c.EnabledFlags("features"), ","); flags != "epsilon,zeta,alpha,delta" {
+		t.Errorf("features: expect epsilon,zeta,alpha,delta, got %s", flags)
+	}
+	if flags := strings.Join(c.EnabledFlags(""), ","); flags != "alpha,beta,delta" {
+		t.Errorf("DEFAULT: expect alpha,beta,delta, got %s", flags)
+	}
+	if flags := c.EnabledFlags("missing"); len(flags) != 0 {
+		t.Errorf("missing: expect no flag, got %v", flags)
+	}
+
+	c.SetFallbackSection("")
+	if flags := strings.Join(c.EnabledFlags("features"), ","); flags != "epsilon,zeta" {
+		t.Errorf("features without fallback: expect epsilon,zeta, got %s", flags)
+	}
+	c.SetValue("base", "theta", "yes")
+	c.SetFallbackSection("base")
+	if flags := strings.Join(c.EnabledFlags("features"), ","); flags != "epsilon,zeta,theta" {
+		t.Errorf("features with fallback base: expect epsilon,zeta,theta, got %s", flags)
+	}
+}
+
+func Test_GetComments(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("# Global\nkey = value\n\n; App\n; settings\n[app]\n# Name\nname = abc\nversion = 1.0\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if comments := c.GetSectionComments("app"); comments != "; App"+LineBreak+"; settings" {
+		t.Errorf("app: unexpected comments %q", comments)
+	}
+	if comments := c.GetKeyComments("app", "name"); comments != "# Name" {
+		t.Errorf("app.name: unexpected comments %q", comments)
+	}
+	if comments := c.GetKeyComments("", "key"); comments != "# Global" {
+		t.Errorf("DEFAULT.key: unexpected comments %q", comments)
+	}
+	if c.GetKeyComments("app", "version") != "" || c.GetSectionComments("missing") != "" || c.GetKeyComments("missing", "key") != "" {
+		t.Error("expect empty comments for missing ones")
+	}
+}
+
+func Test_OrLog(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "port", "8080")
+	c.SetValue("app", "bad", "abc")
+
+	var errs []error
+	log := func(err error) { errs = append(errs, err) }
+
+	if v := c.IntOrLog("app", "port", 80, log); v != 8080 || len(errs) != 0 {
+		t.Errorf("IntOrLog(port): expect 8080 without error, got %d %v", v, errs)
+	}
+	if v := c.IntOrLog("app", "bad", 80, log); v != 80 {
+		t.Errorf("IntOrLog(bad): expect 80, got %d", v)
+	}
+	if v := c.BoolOrLog("app", "bad", true, log); !v {
+		t.Error("BoolOrLog(bad): expect true")
+	}
+	if v := c.Float64OrLog("app", "missing", 0.5, log); v != 0.5 {
+		t.Errorf("Float64OrLog(missing): expect 0.5, got %f", v)
+	}
+	if v := c.DurationOrLog("app", "bad", time.Second, nil); v != time.Second {
+		t.Errorf("DurationOrLog(bad): expect 1s, got %s", v)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("log: expect 3 errors, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "[app] bad: ") {
+		t.Errorf("log: expect error with section and key, got %v", errs[0])
+	}
+	var ge getError
+	if !errors.As(errs[2], &ge) || ge.Reason != ERR_KEY_NOT_FOUND {
+		t.Errorf("log: expect wrapped key not found error, got %v", errs[2])
+	}
+}
+
+func Test_ParseBool(t *testing.T) {
+	for _, value := range []string{"true", "True", "T", "yes", "Y", "ON", "1", "Enabled"} {
+		if b, err := parseBool(value); err != nil || !b {
+			t.Errorf("parseBool(%s): expect true, got %v (%v)", value, b, err)
+		}
+	}
+	for _, value := range []string{"false", "FALSE", "f", "No", "n", "off", "0", "disabled"} {
+		if b, err := parseBool(value); err != nil || b {
+			t.Errorf("parseBool(%s): expect false, got %v (%v)", value, b, err)
+		}
+	}
+	for _, value := range []string{"", "maybe", "2", "enable"} {
+		if _, err := parseBool(value); err == nil {
+			t.Errorf("parseBool(%s): expect error", value)
+		}
+	}
+
+	c := newConfigFile(nil)
+	c.SetValue("app", "debug", "yes")
+	if !c.MustBool("app", "debug") {
+		t.Error("app.debug: expect true")
+	}
+}
+
+func Test_ErrorsIs(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+
+	_, err := c.GetValue("app", "missing")
+	if !errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("app.missing: expect ErrKeyNotFound, got %v", err)
+	}
+	_, err = c.Int("missing", "name")
+	if !errors.Is(err, ErrSectionNotFound) || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("missing.name: expect ErrSectionNotFound, got %v", err)
+	}
+	if err.Error() != "section 'missing' not found" {
+		t.Errorf("missing.name: unexpected message %q", err.Error())
+	}
+
+	// Wrapped errors match too.
+	c.IntOrLog("app", "missing", 0, func(err error) {
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("IntOrLog: expect ErrKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func Test_SetMetricsHook(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "port", "8080")
+
+	var lookups []string
+	c.SetMetricsHook(func(section, key string, found bool, dur time.Duration) {
+		lookups = append(lookups, section+"."+key+"="+strconv.FormatBool(found))
+		if dur < 0 {
+			t.Errorf("hook: expect non-negative duration, got %s", dur)
+		}
+		// Hook runs outside the lock.
+		c.SetValue("app", "hooked", "true")
+	})
+
+	c.MustInt("app", "port")
+	c.GetValue("app", "missing")
+	if strings.Join(lookups, ",") != "app.port=true,app.missing=false" {
+		t.Errorf("hook: unexpected lookups %v", lookups)
+	}
+
+	c.SetMetricsHook(nil)
+	c.GetValue("app", "port")
+	if len(lookups) != 2 {
+		t.Errorf("hook: expect no call after removed, got %v", lookups)
+	}
+}
+
+func Test_DefaultFallback(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("timeout = 30\nname = default\n[app]\nname = abc\n[app.web]\nport = 80\n[db.master]\nhost = localhost\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	tests := []struct{ section, key, expect string }{
+		{"app", "timeout", "30"},
+		{"app", "name", "abc"},
+		{"app.web", "name", "abc"},
+		{"app.web", "timeout", "30"},
+		{"db.master", "timeout", "30"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s' (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+	if _, err = c.GetValue("app", "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("app.missing: expect ErrKeyNotFound, got %v", err)
+	}
+	if _, err = c.GetValue("missing", "timeout"); !errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("missing.timeout: expect ErrSectionNotFound, got %v", err)
+	}
+}
+
+func Test_SetFallbackSection(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("timeout = 30\n[common]\ntimeout = 60\n[prod]\nname = abc\n[prod.web]\nport = 80\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	c.SetFallbackSection("common")
+	if v, err := c.GetValue("prod", "timeout"); err != nil || v != "60" {
+		t.Errorf("prod.timeout: expect '60', got '%s' (%v)", v, err)
+	}
+
+	c.SetFallbackSection("")
+	if _, err := c.GetValue("prod", "timeout"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("prod.timeout: expect ErrKeyNotFound, got %v", err)
+	}
+	if _, err := c.GetValue("prod.web", "name"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("prod.web.name: expect ErrKeyNotFound, got %v", err)
+	}
+	if v, err := c.GetValue("prod.web", "port"); err != nil || v != "80" {
+		t.Errorf("prod.web.port: expect '80', got '%s' (%v)", v, err)
+	}
+
+	c.Reset()
+	c.SetValue(DEFAULT_SECTION, "timeout", "30")
+	c.SetValue("prod", "name", "abc")
+	if v, err := c.GetValue("prod", "timeout"); err != nil || v != "30" {
+		t.Errorf("prod.timeout: expect '30' after Reset, got '%s' (%v)", v, err)
+	}
+}
+
+func Test_TransformValues(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "name", "abc")
+	c.SetValue("db", "host", "master")
+	c.SetValue("db", "user", "root")
+
+	c.TransformValues(func(section, key, value string) (string, bool) {
+		if section != "db" {
+			return "", false
+		}
+		return strings.ToUpper(value), true
+	})
+
+	tests := []struct{ section, key, expect string }{
+		{"db", "host", "MASTER"},
+		{"db", "user", "ROOT"},
+		{DEFAULT_SECTION, "name", "abc"},
+	}
+	for _, test := range tests {
+		if v, _ := c.GetValue(test.section, test.key); v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s'", test.section, test.key, test.expect, v)
+		}
+	}
+}
+
+func Test_GetValueReader(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "name", "abc")
+	c.SetValue("app", "script", "echo %(name)s\nexit 0")
+
+	r, err := c.GetValueReader("app", "script")
+	if err != nil {
+		t.Fatalf("GetValueReader: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "echo abc\nexit 0" {
+		t.Errorf("GetValueReader: unexpected content %q (%v)", data, err)
+	}
+	if _, err = c.GetValueReader("app", "missing"); err == nil {
+		t.Error("GetValueReader(missing): expect error")
+	}
+}
+
+func Test_EmptySectionEnumeration(t *testing.T) {
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader("[empty]\n\n[app]\nname = abc\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if values, err := c.GetSection("empty"); err != nil || len(values) != 0 {
+		t.Errorf("GetSection: expect empty section, got %v (%v)", values, err)
+	}
+	if keys, ok := c.Order()["empty"]; !ok || len(keys) != 0 {
+		t.Errorf("Order: expect empty section, got %v", keys)
+	}
+	if values, ok := c.Resolved()["empty"]; !ok || len(values) != 0 {
+		t.Errorf("Resolved: expect empty section, got %v", values)
+	}
+	c.Range(func(section, key, value string) bool {
+		if section == "empty" || strings.TrimSpace(key) == "" {
+			t.Errorf("Range: unexpected key '%s' in section '%s'", key, section)
+		}
+		return true
+	})
+	if st := c.Stats(); st.Sections != 2 || st.Keys != 1 {
+		t.Errorf("Stats: expect 2 sections and 1 key, got %+v", st)
+	}
+	if out := saveString(t, c); out != "[empty]\n\n[app]\nname = abc\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+	if _, err := c.GetValue("empty", " "); err == nil {
+		t.Error("empty.' ': expect key not found")
+	}
+}
+
+func Test_Clone(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+	c.SetKeyComments("app", "name", "# name")
+	c.CaseInsensitive = true
+
+	cc := c.Clone()
+	if !cc.CaseInsensitive || !cc.Equal(c) {
+		t.Fatal("Clone: expect equal configuration with the same options")
+	}
+	cc.SetValue("APP", "name", "def")
+	cc.SetValue("app", "port", "80")
+	cc.SetValue("db", "host", "localhost")
+	cc.SetKeyComments("app", "name", "# changed")
+
+	if v := c.MustValue("app", "name"); v != "abc" {
+		t.Errorf("app.name: expect 'abc', got '%s'", v)
+	}
+	if order := c.Order(); len(order) != 1 || len(order["app"]) != 1 {
+		t.Errorf("Order: expect only app.name, got %v", order)
+	}
+	if comments := c.GetKeyComments("app", "name"); comments != "# name" {
+		t.Errorf("GetKeyComments: expect '# name', got '%s'", comments)
+	}
+}
+
+func Test_Merge(t *testing.T) {
+	a := newConfigFile(nil)
+	a.SetValue("app", "name", "a")
+	a.SetValue("app", "port", "80")
+	a.SetKeyComments("app", "port", "port comments")
+
+	b := newConfigFile(nil)
+	b.SetValue("app", "port", "8080")
+	b.SetValue("app", "debug", "true")
+	b.SetValue("db", "host", "localhost")
+	b.SetSectionComments("db", "db comments")
+
+	a.Merge(b)
+	expect := "[app]\nname = a\n; port comments\nport = 8080\ndebug = true\n\n; db comments\n[db]\nhost = localhost\n"
+	if out := saveString(t, a); out != expect {
+		t.Errorf("saved: expect\n%s\ngot\n%s", expect, out)
+	}
+}
+
+func Test_StrictVarsInterpolation(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "port", "80")
+	c.SetValue("app", "url", "http://%(host)s:%(port)s/%(path)s")
+	c.SetValue("app", "ok", "http://%(host)s:%(port)s")
+
+	if v, err := c.GetValue("app", "url"); err != nil || v != "http://localhost:80/" {
+		t.Errorf("app.url: expect 'http://localhost:80/', got '%s' (%v)", v, err)
+	}
+
+	c.StrictVars = true
+	if _, err := c.GetValue("app", "url"); err == nil || err.Error() != "variable 'path' not defined" {
+		t.Errorf("app.url: expect undefined variable error, got %v", err)
+	}
+	if _, err := c.GetValueOnce("app", "url"); err == nil || err.Error() != "variable 'path' not defined" {
+		t.Errorf("GetValueOnce(app.url): expect undefined variable error, got %v", err)
+	}
+	if v, err := c.GetValue("app", "ok"); err != nil || v != "http://localhost:80" {
+		t.Errorf("app.ok: expect 'http://localhost:80', got '%s' (%v)", v, err)
+	}
+}
+
+func Test_VariableNames(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "hosts", "a,b")
+	c.SetValue(DEFAULT_SECTION, "class", "x")
+	c.SetValue(DEFAULT_SECTION, "(paren", "p")
+	c.SetValue(DEFAULT_SECTION, "%percent", "q")
+	c.SetValue("app", "ss", "s")
+	c.SetValue("app", "value", "%(hosts)s|%(class)s|%(ss)s|%((paren)s|%(%percent)s")
+
+	if v, err := c.GetValue("app", "value"); err != nil || v != "a,b|x|s|p|q" {
+		t.Errorf("app.value: expect 'a,b|x|s|p|q', got '%s' (%v)", v, err)
+	}
+}
+
+func Test_CircularReference(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "a", "%(b)s")
+	c.SetValue(DEFAULT_SECTION, "b", "x-%(a)s")
+	c.SetValue(DEFAULT_SECTION, "home", "/usr")
+	c.SetValue("app", "x", "%(y)s")
+	c.SetValue("app", "y", "%(x)s")
+	c.SetValue("app", "home", "%(home)s/app")
+	c.SetValue("app", "z", "%(a)s")
+
+	tests := []struct {
+		section, key, err string
+	}{
+		{DEFAULT_SECTION, "a", "circular reference: a -> b -> a"},
+		{"app", "x", "circular reference: x -> y -> x"},
+		{"app", "z", "circular reference: a -> b -> a"},
+	}
+	for _, test := range tests {
+		if _, err := c.GetValue(test.section, test.key); err == nil || err.Error() != test.err {
+			t.Errorf("%s.%s: expect %q, got %v", test.section, test.key, test.err, err)
+		}
+	}
+
+	// Key which refers to the same key in DEFAULT section is not a cycle.
+	if v, err := c.GetValue("app", "home"); err != nil || v != "/usr/app" {
+		t.Errorf("app.home: expect '/usr/app', got '%s' (%v)", v, err)
+	}
+}
+
+func Test_NewConfigFile(t *testing.T) {
+	for _, blockMode := range []bool{true, false} {
+		c := NewConfigFile(blockMode)
+		if c.BlockMode != blockMode {
+			t.Errorf("BlockMode: expect %v, got %v", blockMode, c.BlockMode)
+		}
+		c.SetValue("app", "name", "abc")
+		c.SetSectionComments("app", "application")
+		c.SetKeyComments("app", "name", "name of app")
+		if v := c.MustValue("app", "name"); v != "abc" {
+			t.Errorf("app.name: expect 'abc', got '%s'", v)
+		}
+		if out := saveString(t, c); out != "; application\n[app]\n; name of app\nname = abc\n" {
+			t.Errorf("saved: unexpected result %q", out)
+		}
+	}
+}
+
+func Test_ConcurrentComments(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				section := "s" + strconv.Itoa(n*100+i)
+				c.SetSectionComments(section, "comment")
+				c.SetKeyComments(section, "key", "comment")
+				c.SetKeyComments("app", "name", strconv.Itoa(i))
+			}
+		}(n)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.GetKeyComments("app", "name")
+				c.GetSectionComments("s1")
+				c.WriteTo(io.Discard)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if comments := c.GetKeyComments("app", "name"); comments != "; 99" {
+		t.Errorf("app.name comments: expect '; 99', got '%s'", comments)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "timeout", "30s")
+	c.SetValue("app", "name", "abc")
+	c.SetValue("app", "port", "80x")
+	c.SetValue("app", "debug", "on")
+
+	err := c.Validate(map[string][]string{
+		"app": {"name", "timeout<duration>", "debug<bool>", "port<int>", "host", "name<color>"},
+		"db":  {"host", "port"},
+	})
+	expect := "invalid configuration: [app] port: strconv.Atoi: parsing \"80x\": invalid syntax; " +
+		"[app] host: key 'host' not found; [app] name: unknown type 'color'; [db]: section 'db' not found"
+	if err == nil || err.Error() != expect {
+		t.Errorf("Validate: expect\n%s\ngot\n%v", expect, err)
+	}
+
+	if err = c.Validate(map[string][]string{"app": {"name<string>", "timeout"}, "": {"timeout<duration>"}}); err != nil {
+		t.Errorf("Validate: expect no error, got %v", err)
+	}
+}
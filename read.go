@@ -2,32 +2,109 @@ package goconfig
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
-// readError occurs when read configuration file with wrong format.
-type readError struct {
+// ReadError occurs when read configuration file with wrong format.
+// Loading functions return it as is, so callers can check Reason
+// for the kind of failure and Content for the offending line.
+type ReadError struct {
 	Reason  ParseError
 	Content string // Line content
+	Line    int    // Line number, starts from 1, or 0 if unknown.
+	Err     error  // Underlying error of invalid name or include, or nil.
 }
 
 // Error implement Error interface.
-func (err readError) Error() string {
+func (err ReadError) Error() string {
+	if err.Line > 0 {
+		return fmt.Sprintf("line %d: %s", err.Line, err.message())
+	}
+	return err.message()
+}
+
+// message returns the error message without line number.
+func (err ReadError) message() string {
 	switch err.Reason {
 	case ERR_BLANK_SECTION_NAME:
 		return "empty section name not allowed"
 	case ERR_COULD_NOT_PARSE:
 		return fmt.Sprintf("could not parse line: %s", string(err.Content))
+	case ERR_DUPLICATE_KEY:
+		return fmt.Sprintf("duplicate key: %s", err.Content)
+	case ERR_INVALID_NAME:
+		return err.Err.Error()
+	case ERR_INCLUDE:
+		return fmt.Sprintf("%s: %v", err.Content, err.Err)
 	}
 	return "invalid read error"
 }
 
+// Unwrap returns the underlying error.
+func (err ReadError) Unwrap() error {
+	return err.Err
+}
+
+// readMultiline reads lines from buf until the one with closing `"""`
+// of value starts with first, line breaks between lines are kept as "\n".
+// It returns the value, the rest of the last line after the quote,
+// all lines read as is, and false if the quote is never closed.
+func readMultiline(buf *bufio.Reader, first string) (string, string, []string, bool) {
+	value := first
+	var consumed []string
+	for {
+		next, err := buf.ReadString('\n')
+		if len(next) > 0 {
+			consumed = append(consumed, next)
+		}
+		next = strings.TrimRight(next, "\r\n")
+		if j := strings.Index(next, `"""`); j > -1 {
+			return value + "\n" + next[:j], next[j+3:], consumed, true
+		}
+		if err != nil {
+			return "", "", consumed, false
+		}
+		value += "\n" + next
+	}
+}
+
+// indentKey is a key with its indentation, see IndentNesting.
+type indentKey struct {
+	indent       int
+	section, key string
+}
+
+// subSection returns name of the sub-section named by the key.
+func (k indentKey) subSection() string {
+	if k.section == DEFAULT_SECTION {
+		return k.key
+	}
+	return k.section + "." + k.key
+}
+
+// errConfigNotFound occurs when configuration file does not exist.
+var errConfigNotFound = errors.New("config path not found")
+
+// STDIN_FILE_NAME is the file name which reads configuration from stdin.
+const STDIN_FILE_NAME = "-"
+
+// INHERITS_ANNOTATION declares the inherited section in comment of
+// section header, see SetSectionParent. Any other comment of section
+// header is added to section comments.
+const INHERITS_ANNOTATION = "@inherits"
+
 // LoadConfigFile reads a file and returns a new configuration representation.
 // This representation can be queried with GetValue.
+// File name with prefix "?" is optional, it is skipped when it does not exist,
+// use LoadedFiles to know which files are actually loaded.
+// File name "-" reads from stdin, such configuration can't be reloaded.
 func LoadConfigFile(fileName string, moreFiles ...string) (c *ConfigFile, err error) {
 	// Append files' name together.
 	fileNames := make([]string, 1, len(moreFiles)+1)
@@ -47,33 +124,122 @@ func LoadConfigFile(fileName string, moreFiles ...string) (c *ConfigFile, err er
 	return c, nil
 }
 
+// LoadFromReader reads r and returns a new configuration representation.
+// It has no file, so Reload returns an error.
+func LoadFromReader(r io.Reader) (*ConfigFile, error) {
+	c := newConfigFile(nil)
+	if err := c.read(r); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFromBytes is like LoadFromReader but reads b.
+func LoadFromBytes(b []byte) (*ConfigFile, error) {
+	return LoadFromReader(bytes.NewReader(b))
+}
+
+// LoadFromString is like LoadFromReader but reads s.
+func LoadFromString(s string) (*ConfigFile, error) {
+	return LoadFromReader(strings.NewReader(s))
+}
+
+// AppendFiles reads more files into c in order, their keys overwrite
+// existing ones. Names are appended to files of c, so Reload reads them too.
+// If a file fails to load, files before it are kept loaded.
+func (c *ConfigFile) AppendFiles(fileNames ...string) error {
+	for _, name := range fileNames {
+		if err := c.loadFile(name); err != nil {
+			return err
+		}
+
+		if c.BlockMode {
+			c.lock.Lock()
+		}
+		c.fileNames = append(c.fileNames, name)
+		if c.BlockMode {
+			c.lock.Unlock()
+		}
+	}
+	return nil
+}
+
 func (c *ConfigFile) loadFile(fileName string) (err error) {
-	AppPath, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	// Only the first file is at the top.
+	top := len(c.LoadedFiles()) == 0
+	if fileName == STDIN_FILE_NAME {
+		if err = c.parse(os.Stdin, "", nil, top); err != nil {
+			return err
+		}
+		c.addLoadedFile(fileName)
+		return nil
+	}
+
+	// Check if it's optional.
+	optional := strings.HasPrefix(fileName, "?")
+	if optional {
+		fileName = fileName[1:]
+	}
+
+	appConfigPath, err := configPath(fileName)
 	if err != nil {
+		if optional && err == errConfigNotFound {
+			return nil
+		}
 		return err
 	}
 
-	workPath, err := os.Getwd()
+	f, err := os.Open(appConfigPath)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+
+	if err = c.parse(f, filepath.Dir(appConfigPath), []string{appConfigPath}, top); err != nil {
+		return err
+	}
+	c.addLoadedFile(fileName)
+	return nil
+}
+
+// addLoadedFile appends file name to LoadedFiles.
+func (c *ConfigFile) addLoadedFile(fileName string) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	c.loadedFiles = append(c.loadedFiles, fileName)
+}
+
+// configPath returns the path of configuration file,
+// relative file name is looked up in working directory then application directory.
+func configPath(fileName string) (string, error) {
+	if filepath.IsAbs(fileName) {
+		if !fileExists(fileName) {
+			return "", errConfigNotFound
+		}
+		return fileName, nil
+	}
+
+	AppPath, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return "", err
+	}
+
+	workPath, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
 
 	appConfigPath := ""
 	appConfigPath = filepath.Join(workPath, fileName)
 	if !fileExists(appConfigPath) {
 		appConfigPath = filepath.Join(AppPath, fileName)
 		if !fileExists(appConfigPath) {
-			return errors.New("config path not found")
+			return "", errConfigNotFound
 		}
 	}
-
-	f, err := os.Open(appConfigPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	return c.read(f)
+	return appConfigPath, nil
 }
 
 // FileExists reports whether the named file or directory exists.
@@ -89,6 +255,115 @@ func fileExists(name string) bool {
 // Read reads an io.Reader and returns a configuration representation.
 // This representation can be queried with GetValue.
 func (c *ConfigFile) read(reader io.Reader) (err error) {
+	return c.parse(reader, "", nil, true)
+}
+
+// delimiters returns Delimiters or the default ones.
+func (c *ConfigFile) delimiters() string {
+	if len(c.Delimiters) == 0 {
+		return "=:"
+	}
+	return c.Delimiters
+}
+
+// inlineCommentIndex returns the index of inline comment in value,
+// which starts with a comment prefix after a space, or -1 if there is none.
+func (c *ConfigFile) inlineCommentIndex(value string) int {
+	for i := 1; i < len(value); i++ {
+		if (value[i-1] == ' ' || value[i-1] == '\t') && len(c.commentPrefix(value[i:])) > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// doubleQuoted splits value wrapped with double quotes, e.g. "a ; b" ; note,
+// into the value and its inline comment, see InlineComment.
+// It returns value as is if it is not quoted or the quote is followed
+// by anything but a comment.
+func (c *ConfigFile) doubleQuoted(value string) (string, string, bool) {
+	if len(value) < 2 || value[0] != '"' {
+		return value, "", false
+	}
+	for i := 1; i < len(value); i++ {
+		if value[i] != '"' {
+			continue
+		}
+		if rest := strings.TrimSpace(value[i+1:]); len(rest) == 0 || len(c.commentPrefix(rest)) > 0 {
+			return value[1:i], rest, true
+		}
+	}
+	return value, "", false
+}
+
+// include reads the file of an "!include path" line into c.
+// Relative path is resolved against dir, the directory of including file,
+// and files is the chain of including files to detect include cycles.
+func (c *ConfigFile) include(path, dir string, files []string) error {
+	path, err := includePath(path, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if name == path {
+			return fmt.Errorf("include cycle: %s -> %s", strings.Join(files, " -> "), path)
+		}
+	}
+	if len(files) >= _DEPTH_INCLUDES {
+		return fmt.Errorf("include nested too deep: %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.parse(f, filepath.Dir(path), append(files[:len(files):len(files)], path), false)
+}
+
+// importGlob includes every file matched by pattern of an "@import pattern"
+// line in sorted order, no match is not an error.
+func (c *ConfigFile) importGlob(pattern, dir string, files []string) error {
+	pattern, err := includePath(pattern, dir)
+	if err != nil {
+		return err
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for _, path := range matches {
+		if err = c.include(path, dir, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includePath returns path resolved against dir, or working directory
+// if dir is empty.
+func includePath(path, dir string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	if len(dir) == 0 {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, path), nil
+}
+
+// parse is the implementation of read, dir and files are passed to include.
+// Included file starts in DEFAULT section and does not change current
+// section of including file, its keys are overwritten by later keys.
+// Only the first file read into c is at the top, whose leading comments
+// can be comments of DEFAULT section.
+func (c *ConfigFile) parse(reader io.Reader, dir string, files []string, top bool) (err error) {
 	buf := bufio.NewReader(reader)
 
 	// Handle BOM-UTF8.
@@ -103,10 +378,32 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 	// Current section name.
 	section := DEFAULT_SECTION
 	var comments string
+	// Whether only comments and empty lines are read so far, see top.
+	// Keys read so far for StrictDuplicates.
+	seen := make(map[string]bool)
+	// Number of lines read so far.
+	lines := 0
+	// Keys whose sub-sections current key is in, and last key with empty
+	// value which is a parent if the next key is more indented.
+	var parents []indentKey
+	var candidate *indentKey
 	// Parse line-by-line
 	for {
 		line, err := buf.ReadString('\n')
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
 		line = strings.TrimSpace(line)
+		lines++
+		// Line number of where current line starts, for ReadError.
+		lineNum := lines
+		// Line ending with backslash continues on the next line,
+		// see LineContinuation.
+		for c.LineContinuation && err == nil && strings.HasSuffix(line, `\`) &&
+			len(c.commentPrefix(line)) == 0 {
+			var next string
+			next, err = buf.ReadString('\n')
+			line = line[:len(line)-1] + strings.TrimSpace(next)
+			lines++
+		}
 		lineLengh := len(line) //[SWH|+]
 		if err != nil {
 			if err != io.EOF {
@@ -120,11 +417,33 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 			}
 		}
 
+		// Check if section header has a trailing comment, e.g. "[web] ; @inherits=common".
+		var headerComment, headerAnnotation string
+		if lineLengh > 0 && line[0] == '[' && line[lineLengh-1] != ']' {
+			if j := strings.LastIndex(line, "]"); j > 0 {
+				rest := strings.TrimSpace(line[j+1:])
+				if prefix := c.commentPrefix(rest); len(prefix) > 0 {
+					headerComment = rest
+					headerAnnotation = strings.TrimSpace(rest[len(prefix):])
+					line = line[:j+1]
+					lineLengh = len(line)
+				}
+			}
+		}
+
+		// Comments at the top followed by empty line belong to DEFAULT section.
+		if top && lineLengh == 0 && len(comments) > 0 {
+			c.SetSectionComments(DEFAULT_SECTION, comments)
+			comments = ""
+			top = false
+		}
+		top = top && (lineLengh == 0 || len(c.commentPrefix(line)) > 0)
+
 		// switch written for readability (not performance)
 		switch {
 		case lineLengh == 0: // Empty line
 			continue
-		case line[0] == '#' || line[0] == ';': // Comment
+		case len(c.commentPrefix(line)) > 0: // Comment
 			// Append comments
 			if len(comments) == 0 {
 				comments = line
@@ -132,28 +451,73 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 				comments += LineBreak + line
 			}
 			continue
+		case strings.HasPrefix(line, "!include "): // Include another file.
+			if err := c.include(strings.TrimSpace(line[9:]), dir, files); err != nil {
+				return ReadError{ERR_INCLUDE, line, lineNum, err}
+			}
+		case strings.HasPrefix(line, "@import "): // Include files matched by glob pattern.
+			if err := c.importGlob(strings.TrimSpace(line[8:]), dir, files); err != nil {
+				return ReadError{ERR_INCLUDE, line, lineNum, err}
+			}
 		case line[0] == '[' && line[lineLengh-1] == ']': // New sction.
 			// Get section name.
 			section = strings.TrimSpace(line[1 : lineLengh-1])
+			// Check if it's a new element of section array.
+			isArray := c.SectionArrays && lineLengh > 4 && line[1] == '[' && line[lineLengh-2] == ']'
+			if isArray {
+				section = strings.TrimSpace(line[2 : lineLengh-2])
+			}
+			// Quotes delimit the name as is, e.g. ["my [weird] section"].
+			quotedSection := len(section) >= 2 && section[0] == '"' && section[len(section)-1] == '"'
+			if quotedSection {
+				section = section[1 : len(section)-1]
+			}
+			if c.StrictNames && !quotedSection {
+				if err := CheckSectionName(section); err != nil {
+					return ReadError{ERR_INVALID_NAME, line, lineNum, err}
+				}
+			}
+			if isArray {
+				section = c.nextArraySection(section)
+			}
+			parents, candidate = nil, nil
+			// Trailing comment other than annotation is kept with section comments.
+			isInherits := strings.HasPrefix(headerAnnotation, INHERITS_ANNOTATION)
+			if len(headerComment) > 0 && !isInherits {
+				if len(comments) == 0 {
+					comments = headerComment
+				} else {
+					comments += LineBreak + headerComment
+				}
+			}
 			// Set section comments and empty if it has comments.
 			if len(comments) > 0 {
-				c.setSectionComments(section, comments)
+				c.SetSectionComments(section, comments)
 				comments = ""
 			}
 			// Make section exist even though it does not have any key.
-			c.setValue(section, " ", " ")
+			c.addSection(section)
+			if isInherits {
+				if parent := strings.TrimSpace(headerAnnotation[len(INHERITS_ANNOTATION):]); strings.HasPrefix(parent, "=") {
+					c.SetSectionParent(section, strings.TrimSpace(parent[1:]))
+				}
+			}
 			// Reset counter.
 			count = 1
 			continue
 		case section == "": // No section defined so far
-			return readError{ERR_BLANK_SECTION_NAME, line}
+			return ReadError{ERR_BLANK_SECTION_NAME, line, lineNum, nil}
 		default: // Other alternatives
 			var (
 				i        int
 				keyQuote string
 				key      string
+				keyType  string
+				quoted   bool
 				valQuote string
 				value    string
+
+				inlineComment string
 			)
 			//[SWH|+]:支持引号包围起来的字串
 			if line[0] == '"' {
@@ -169,21 +533,28 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 				qLen := len(keyQuote)
 				pos := strings.Index(line[qLen:], keyQuote)
 				if pos == -1 {
-					return readError{ERR_COULD_NOT_PARSE, line}
+					return ReadError{ERR_COULD_NOT_PARSE, line, lineNum, nil}
 				}
 				pos = pos + qLen
-				i = strings.IndexAny(line[pos:], "=:")
+				i = strings.IndexAny(line[pos:], c.delimiters())
 				if i <= 0 {
-					return readError{ERR_COULD_NOT_PARSE, line}
+					return ReadError{ERR_COULD_NOT_PARSE, line, lineNum, nil}
 				}
 				i = i + pos
 				key = line[qLen:pos] //保留引号内的两端的空格
 			} else {
-				i = strings.IndexAny(line, "=:")
+				i = strings.IndexAny(line, c.delimiters())
 				if i <= 0 {
-					return readError{ERR_COULD_NOT_PARSE, line}
+					return ReadError{ERR_COULD_NOT_PARSE, line, lineNum, nil}
 				}
 				key = strings.TrimSpace(line[0:i])
+				// Check if it has type annotation.
+				if c.TypeAnnotations && key[len(key)-1] == '>' {
+					if j := strings.Index(key, "<"); j > 0 {
+						keyType = strings.TrimSpace(key[j+1 : len(key)-1])
+						key = strings.TrimSpace(key[:j])
+					}
+				}
 			}
 			//[SWH|+];
 
@@ -202,26 +573,123 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 			}
 			if firstChar == "`" {
 				valQuote = "`"
-			} else if lineRightLength >= 6 && lineRight[0:3] == `"""` {
+			} else if lineRightLength >= 3 && lineRight[0:3] == `"""` {
 				valQuote = `"""`
 			}
 			if valQuote != "" {
 				qLen := len(valQuote)
 				pos := strings.LastIndex(lineRight[qLen:], valQuote)
-				if pos == -1 {
-					return readError{ERR_COULD_NOT_PARSE, line}
+				var rest string // After the closing quote.
+				if pos > -1 {
+					pos = pos + qLen
+					value, rest, quoted = lineRight[qLen:pos], lineRight[pos+qLen:], true
+				} else if valQuote == `"""` && err == nil {
+					// Value continues on the next lines until the closing quote.
+					var consumed []string
+					value, rest, consumed, quoted = readMultiline(buf, lineRight[qLen:])
+					if !quoted {
+						if !c.LenientQuotes {
+							return ReadError{ERR_COULD_NOT_PARSE, line, lineNum, nil}
+						}
+						// Quote is never closed, take it as a literal
+						// and read the next lines again.
+						buf = bufio.NewReader(strings.NewReader(strings.Join(consumed, "")))
+						value = lineRight
+					} else {
+						lines += len(consumed)
+					}
+				} else {
+					if !c.LenientQuotes {
+						return ReadError{ERR_COULD_NOT_PARSE, line, lineNum, nil}
+					}
+					// Quote is never closed, take it as a literal.
+					value = lineRight
+				}
+				// Check if it has inline comment after the quote.
+				if rest = strings.TrimSpace(rest); c.InlineComment && len(c.commentPrefix(rest)) > 0 {
+					inlineComment = rest
 				}
-				pos = pos + qLen
-				value = lineRight[qLen:pos]
 			} else {
 				value = strings.TrimSpace(lineRight[0:])
+				if c.InlineComment {
+					value, inlineComment, quoted = c.doubleQuoted(value)
+					if quoted {
+						valQuote = `"`
+					}
+				}
+				if c.InlineComment && !quoted {
+					if j := c.inlineCommentIndex(value); j > -1 {
+						inlineComment = value[j:]
+						value = strings.TrimSpace(value[:j])
+					}
+				}
 			}
 			//[SWH|+];
 
-			c.setValue(section, key, value)
+			if len(inlineComment) > 0 {
+				if len(comments) > 0 {
+					comments += LineBreak + inlineComment
+				} else {
+					comments = inlineComment
+				}
+			}
+
+			keySection := section
+			if c.IndentNesting {
+				// Leave sub-sections of keys which are not less indented.
+				for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+					parents = parents[:len(parents)-1]
+				}
+				if candidate != nil && indent > candidate.indent {
+					// Previous key is the parent of this key rather than a key.
+					if parentComments := c.GetKeyComments(candidate.section, candidate.key); len(parentComments) > 0 {
+						c.SetSectionComments(candidate.subSection(), parentComments)
+					}
+					c.DeleteKey(candidate.section, candidate.key)
+					parents = append(parents, *candidate)
+				}
+				candidate = nil
+				if len(parents) > 0 {
+					keySection = parents[len(parents)-1].subSection()
+				}
+				if len(value) == 0 && !quoted {
+					candidate = &indentKey{indent, keySection, key}
+				}
+			}
+
+			if c.StrictNames {
+				if err := CheckKeyName(key, keyQuote != ""); err != nil {
+					return ReadError{ERR_INVALID_NAME, line, lineNum, err}
+				}
+			}
+			repeated := false
+			if c.StrictDuplicates || c.MultiValues {
+				name := keySection + "\x00" + key
+				if c.CaseInsensitive {
+					name = strings.ToLower(name)
+				}
+				if seen[name] {
+					if c.StrictDuplicates {
+						return ReadError{ERR_DUPLICATE_KEY, line, lineNum, nil}
+					}
+					repeated = true
+				}
+				seen[name] = true
+			}
+			if repeated {
+				c.addValue(keySection, key, value)
+			} else {
+				c.setValue(keySection, key, value)
+			}
+			if len(keyType) > 0 {
+				c.setKeyType(keySection, key, keyType)
+			}
+			if quoted {
+				c.setKeyQuote(keySection, key, valQuote)
+			}
 			// Set key comments and empty if it has comments.
 			if len(comments) > 0 {
-				c.setKeyComments(section, key, comments)
+				c.SetKeyComments(keySection, key, comments)
 				comments = ""
 			}
 		}
@@ -231,5 +699,10 @@ func (c *ConfigFile) read(reader io.Reader) (err error) {
 			break
 		}
 	}
+
+	// Comments not followed by any section or key are kept as footer.
+	if len(comments) > 0 {
+		c.addFooterComments(comments)
+	}
 	return nil
 }
@@ -0,0 +1,907 @@
+package goconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_LenientQuotes(t *testing.T) {
+	const conf = "[app]\nname = `not-closed\ndesc = \"\"\"still open\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err == nil {
+		t.Fatal("read: expect error for unterminated quote")
+	}
+
+	c = newConfigFile(nil)
+	c.LenientQuotes = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.getValue("app", "name"); v != "`not-closed" {
+		t.Errorf("app.name: expect '`not-closed', got '%s'", v)
+	}
+	if v, _ := c.getValue("app", "desc"); v != `"""still open` {
+		t.Errorf(`app.desc: expect '"""still open', got '%s'`, v)
+	}
+}
+
+func Test_MultilineValue(t *testing.T) {
+	const conf = "[db]\nquery = \"\"\"SELECT *\n  FROM t\n\"\"\"\nname = abc\n" +
+		"[pem]\nkey = \"\"\"\n-----BEGIN-----\r\nabc\n-----END-----\"\"\"\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	tests := []struct{ section, key, expect string }{
+		{"db", "query", "SELECT *\n  FROM t\n"},
+		{"db", "name", "abc"},
+		{"pem", "key", "\n-----BEGIN-----\nabc\n-----END-----"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect %q, got %q (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+
+	// It is saved with triple quotes and reads back.
+	c.SetValue("db", "note", "line 1\nline 2")
+	cc := newConfigFile(nil)
+	if err := cc.read(strings.NewReader(saveString(t, c))); err != nil {
+		t.Fatalf("read saved: %v", err)
+	}
+	if !cc.Equal(c) {
+		t.Errorf("saved: expect equal configuration, got\n%s", saveString(t, cc))
+	}
+
+	const broken = "[app]\ndesc = \"\"\"open\nname = abc\nport\n"
+	err := newConfigFile(nil).read(strings.NewReader(broken))
+	if e, ok := err.(ReadError); !ok || e.Reason != ERR_COULD_NOT_PARSE || e.Line != 2 {
+		t.Errorf("read: expect parse error at line 2, got %v", err)
+	}
+	c = newConfigFile(nil)
+	c.LenientQuotes = true
+	err = c.read(strings.NewReader(broken))
+	if e, ok := err.(ReadError); !ok || e.Content != "port" || e.Line != 4 {
+		t.Errorf("read: expect parse error at line 4 with LenientQuotes, got %v", err)
+	}
+	if v := c.MustValue("app", "name"); v != "abc" {
+		t.Errorf("app.name: expect 'abc', got '%s'", v)
+	}
+}
+
+func Test_TypeAnnotations(t *testing.T) {
+	const conf = "[app]\ntimeout<duration> = 30s\nname = abc\n"
+
+	c := newConfigFile(nil)
+	c.TypeAnnotations = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.getValue("app", "timeout"); v != "30s" {
+		t.Errorf("app.timeout: expect '30s', got '%s'", v)
+	}
+	if typ := c.ValueType("app", "timeout"); typ != "duration" {
+		t.Errorf("ValueType(app.timeout): expect 'duration', got '%s'", typ)
+	}
+	if typ := c.ValueType("app", "name"); typ != "" {
+		t.Errorf("ValueType(app.name): expect empty, got '%s'", typ)
+	}
+
+	// Annotation is part of key name when it's not enabled.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := c.getValue("app", "timeout<duration>"); err != nil {
+		t.Errorf("app.timeout<duration>: %v", err)
+	}
+}
+
+// writeFile writes content into file name under dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func Test_OptionalFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.conf", "[app]\nname = base\n")
+	overlay := writeFile(t, dir, "overlay.conf", "[app]\nname = overlay\n")
+	absent := filepath.Join(dir, "absent.conf")
+
+	c, err := LoadConfigFile(base, "?"+overlay, "?"+absent)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if v, _ := c.GetValue("app", "name"); v != "overlay" {
+		t.Errorf("app.name: expect 'overlay', got '%s'", v)
+	}
+	if files := c.LoadedFiles(); len(files) != 2 || files[0] != base || files[1] != overlay {
+		t.Errorf("LoadedFiles: expect [%s %s], got %v", base, overlay, files)
+	}
+
+	if _, err = LoadConfigFile(base, absent); err == nil {
+		t.Error("LoadConfigFile: expect error for absent required file")
+	}
+}
+
+func Test_GetValueRawQuotes(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("[app]\nname = `  abc  `\ndesc = \"\"\"say \"hi\"\"\"\"\nplain = \"kept\"\n[app.sub]\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	for _, v := range []struct{ section, key, value, raw string }{
+		{"app", "name", "  abc  ", "`  abc  `"},
+		{"app", "desc", `say "hi"`, `"""say "hi""""`},
+		{"app", "plain", `"kept"`, `"kept"`},
+		{"app.sub", "name", "  abc  ", "`  abc  `"},
+	} {
+		if value, _ := c.GetValue(v.section, v.key); value != v.value {
+			t.Errorf("GetValue(%s.%s): expect %q, got %q", v.section, v.key, v.value, value)
+		}
+		if raw, _ := c.GetValueRawQuotes(v.section, v.key); raw != v.raw {
+			t.Errorf("GetValueRawQuotes(%s.%s): expect %q, got %q", v.section, v.key, v.raw, raw)
+		}
+	}
+}
+
+func Test_EmptySection(t *testing.T) {
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader("[empty]\n[app]\nname = abc\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if len(c.sectionList) != 2 || c.sectionList[0] != "empty" {
+		t.Errorf("sectionList: expect [empty app], got %v", c.sectionList)
+	}
+	if len(c.keyList["empty"]) != 0 || len(c.data["empty"]) != 0 {
+		t.Errorf("empty: expect no key, got %v", c.keyList["empty"])
+	}
+	if _, err := c.GetValue("empty", "name"); err == nil || err.(getError).Reason != ERR_KEY_NOT_FOUND {
+		t.Errorf("empty.name: expect key not found, got %v", err)
+	}
+
+	// Blank header is DEFAULT section.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader("[]\n[app]\nname = abc\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, ok := c.data[""]; ok {
+		t.Errorf("sectionList: expect no blank section, got %v", c.sectionList)
+	}
+	if s := saveString(t, c); s != "[app]\nname = abc\n" {
+		t.Errorf("saved: unexpected result %q", s)
+	}
+}
+
+func Test_SectionArrays(t *testing.T) {
+	const conf = "[[servers]]\nhost = a\n\n[[servers]]\nhost = b\nport = 8080\n\n[app]\nname = abc\n"
+
+	c := newConfigFile(nil)
+	c.SectionArrays = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	servers := c.GetSectionArray("servers")
+	if len(servers) != 2 || servers[0]["host"] != "a" || servers[1]["host"] != "b" || servers[1]["port"] != "8080" {
+		t.Errorf("GetSectionArray: unexpected result %v", servers)
+	}
+	if v, _ := c.GetValue("servers[1]", "host"); v != "b" {
+		t.Errorf("servers[1].host: expect 'b', got '%s'", v)
+	}
+	if len(c.GetSectionArray("app")) != 0 {
+		t.Error("GetSectionArray(app): expect no element")
+	}
+
+	// Without the option it's a section with brackets in name.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.GetValue("[servers]", "host"); v != "b" {
+		t.Errorf("[servers].host: expect 'b', got '%s'", v)
+	}
+}
+
+func Test_CaseInsensitive(t *testing.T) {
+	const conf = "[Database]\nMaxConns = 10\n\n[database]\nmaxconns = 20\nHost = db\n"
+
+	c := newConfigFile(nil)
+	c.CaseInsensitive = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if v, _ := c.GetValue("DATABASE", "maxCONNS"); v != "20" {
+		t.Errorf("DATABASE.maxCONNS: expect '20', got '%s'", v)
+	}
+	if len(c.sectionList) != 1 || c.sectionList[0] != "Database" {
+		t.Errorf("sectionList: expect [Database], got %v", c.sectionList)
+	}
+	if keys := c.keyList["Database"]; len(keys) != 2 || keys[0] != "MaxConns" || keys[1] != "Host" {
+		t.Errorf("keyList: expect [MaxConns Host], got %v", keys)
+	}
+	if out := saveString(t, c); out != "[Database]\nMaxConns = 20\nHost = db\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+
+	if !c.DeleteKey("database", "HOST") || !c.DeleteSection("DataBase") {
+		t.Error("Delete: expect case-insensitive names to be deleted")
+	}
+
+	// Case matters by default.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := c.GetValue("DATABASE", "MaxConns"); err == nil {
+		t.Error("DATABASE.MaxConns: expect section not found")
+	}
+	if v, _ := c.GetValue("Database", "MaxConns"); v != "10" {
+		t.Errorf("Database.MaxConns: expect '10', got '%s'", v)
+	}
+}
+
+func Test_QuotedSectionNames(t *testing.T) {
+	const conf = "[\"my [weird] section\"]\nkey = a\n\n[\" padded \"]\nkey = b\n\n[\"\"quoted\"\"]\nkey = c\n\n[app]\nkey = d\n"
+
+	c := newConfigFile(nil)
+	c.StrictNames = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	tests := []struct{ section, expect string }{
+		{"my [weird] section", "a"},
+		{" padded ", "b"},
+		{`"quoted"`, "c"},
+		{"app", "d"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, "key"); err != nil || v != test.expect {
+			t.Errorf("%q.key: expect '%s', got '%s' (%v)", test.section, test.expect, v, err)
+		}
+	}
+	if out := saveString(t, c); out != conf {
+		t.Errorf("saved: expect\n%s\ngot\n%s", conf, out)
+	}
+}
+
+func Test_LineContinuation(t *testing.T) {
+	const conf = "[app]\nclasspath = a.jar:\\\n    b.jar:\\\n    c.jar\n" +
+		"urls = `http://a, \\\n  http://b`\n" +
+		"next = \\\n; not a comment\n" +
+		"; comment \\\nkept = yes\n" +
+		"last = end\\"
+
+	c := newConfigFile(nil)
+	c.LineContinuation = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	tests := map[string]string{
+		"classpath": "a.jar:b.jar:c.jar",
+		"urls":      "http://a, http://b",
+		"next":      "; not a comment",
+		"kept":      "yes",
+		"last":      "end\\",
+	}
+	for key, expect := range tests {
+		if v, _ := c.GetValue("app", key); v != expect {
+			t.Errorf("%s: expect '%s', got '%s'", key, expect, v)
+		}
+	}
+
+	// Trailing backslash is kept by default.
+	c, err := LoadFromString("[app]\ndir = C:\\temp\\\nport = 80\n")
+	if err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if v := c.MustValue("app", "dir"); v != `C:\temp\` {
+		t.Errorf("dir: expect 'C:\\temp\\', got '%s'", v)
+	}
+	if v := c.MustValue("app", "port"); v != "80" {
+		t.Errorf("port: expect '80', got '%s'", v)
+	}
+
+	// Value with trailing backslash is quoted, so it reads back as is.
+	c = newConfigFile(nil)
+	c.LineContinuation = true
+	c.SetValue("app", "dir", `C:\temp\`)
+	c.SetValue("app", "port", "80")
+	cc := newConfigFile(nil)
+	cc.LineContinuation = true
+	if err = cc.read(strings.NewReader(saveString(t, c))); err != nil {
+		t.Fatalf("read saved: %v", err)
+	}
+	if !cc.Equal(c) {
+		t.Errorf("saved: expect equal configuration, got\n%s", saveString(t, cc))
+	}
+}
+
+func Test_LoadStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	go func() {
+		w.WriteString("[app]\nname = abc\n")
+		w.Close()
+	}()
+
+	c, err := LoadConfigFile(STDIN_FILE_NAME)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if v, _ := c.GetValue("app", "name"); v != "abc" {
+		t.Errorf("app.name: expect 'abc', got '%s'", v)
+	}
+	if _, err = c.ReloadWithDiff(); err != errStdinReload {
+		t.Errorf("ReloadWithDiff: expect %v, got %v", errStdinReload, err)
+	}
+}
+
+func Test_Include(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	writeFile(t, dir, "sub/db.conf", "[db]\nhost = localhost\nport = 3306\n")
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\n!include sub/db.conf\nversion = 1.0\n[db]\nport = 3307\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	tests := []struct{ section, key, expect string }{
+		{"app", "name", "abc"},
+		{"app", "version", "1.0"},
+		{"db", "host", "localhost"},
+		{"db", "port", "3307"},
+	}
+	for _, test := range tests {
+		if v, _ := c.GetValue(test.section, test.key); v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s'", test.section, test.key, test.expect, v)
+		}
+	}
+
+	// Leading comments of included and later files are not DEFAULT comments.
+	inc := writeFile(t, dir, "inc.conf", "; included header\n\nk = v\n")
+	more := writeFile(t, dir, "more.conf", "; more header\n\nm = v\n")
+	if c, err = LoadFromString("; main header\n\n!include " + inc + "\n"); err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	if comments := c.GetSectionComments(DEFAULT_SECTION); comments != "; main header" {
+		t.Errorf("DEFAULT comments: expect '; main header', got '%s'", comments)
+	}
+	if c, err = LoadConfigFile(inc, more); err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if comments := c.GetSectionComments(DEFAULT_SECTION); comments != "; included header" {
+		t.Errorf("DEFAULT comments: expect '; included header', got '%s'", comments)
+	}
+
+	// Include cycle.
+	writeFile(t, dir, "a.conf", "!include b.conf\n")
+	writeFile(t, dir, "b.conf", "!include sub/../a.conf\n")
+	if _, err = LoadConfigFile(filepath.Join(dir, "a.conf")); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("LoadConfigFile: expect include cycle error, got %v", err)
+	}
+}
+
+func Test_StrictNames(t *testing.T) {
+	c := newConfigFile(nil)
+	c.StrictNames = true
+	if err := c.read(strings.NewReader("[a]b]\nkey = value\n")); err == nil || !strings.Contains(err.Error(), "invalid section name") {
+		t.Errorf("read: expect invalid section name error, got %v", err)
+	}
+	c = newConfigFile(nil)
+	c.StrictNames = true
+	if err := c.read(strings.NewReader("[app]\n`key=1` = value\n")); err != nil {
+		t.Errorf("read: expect quoted key to be valid, got %v", err)
+	}
+
+	if c.SetValue("app", "key=2", "value") || c.SetValue("app", "key\n", "value") || c.SetValue("a]b", "key", "value") {
+		t.Error("SetValue: expect invalid names to be rejected")
+	}
+	if _, err := c.GetValue("app", "key=2"); err == nil {
+		t.Error("app.key=2: expect key not found")
+	}
+	if !c.SetValue("app", "key", "value") {
+		t.Error("SetValue: expect valid name to be inserted")
+	}
+
+	// Bulk setters and merge check names too.
+	if inserted, overwritten := c.SetMany([]Entry{{"a]b", "k=1", "v"}, {"app", "k=1", "v"}}); inserted+overwritten != 0 {
+		t.Errorf("SetMany: expect invalid names to be skipped, got %d inserted, %d overwritten", inserted, overwritten)
+	}
+	c.SetValues("app", map[string]string{"k\n": "v", "ok": "v"})
+	o := newConfigFile(nil)
+	o.SetValue("a]b", "k", "v")
+	o.SetValue("app", "k=1", "v")
+	o.SetValue("app", "merged", "v")
+	c.Merge(o)
+	if _, ok := c.data["a]b"]; ok || len(c.data["app"]) != 4 {
+		t.Errorf("SetMany, SetValues, Merge: expect invalid names to be skipped, got %v", c.data)
+	}
+	if c.MustValue("app", "ok") != "v" || c.MustValue("app", "merged") != "v" {
+		t.Error("SetValues, Merge: expect valid names to be set")
+	}
+
+	// Names are not checked by default.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader("[a]b]\nkey = value\n")); err != nil {
+		t.Errorf("read: expect no error by default, got %v", err)
+	}
+	if !c.SetValue("app", "key=2", "value") {
+		t.Error("SetValue: expect name not checked by default")
+	}
+}
+
+func Test_ImportGlob(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	writeFile(t, dir, "conf.d/20-db.conf", "[db]\nhost = db2\nport = 3306\n")
+	writeFile(t, dir, "conf.d/10-db.conf", "[db]\nhost = db1\nuser = root\n")
+	writeFile(t, dir, "conf.d/readme.txt", "not a config\n")
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\n@import conf.d/*.conf\n@import none.d/*.conf\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	tests := []struct{ section, key, expect string }{
+		{"app", "name", "abc"},
+		{"db", "host", "db2"},
+		{"db", "port", "3306"},
+		{"db", "user", "root"},
+	}
+	for _, test := range tests {
+		if v, _ := c.GetValue(test.section, test.key); v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s'", test.section, test.key, test.expect, v)
+		}
+	}
+
+	// Import cycle.
+	writeFile(t, dir, "conf.d/30-loop.conf", "@import ../app.conf\n")
+	if _, err = LoadConfigFile(name); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("LoadConfigFile: expect include cycle error, got %v", err)
+	}
+}
+
+func Test_Delimiters(t *testing.T) {
+	c := newConfigFile(nil)
+	c.Delimiters = " \t"
+	err := c.read(strings.NewReader("[app]\nname abc\nurl\thttp://localhost:8080/?a=b\n`full name` a b c\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	tests := map[string]string{
+		"name":      "abc",
+		"url":       "http://localhost:8080/?a=b",
+		"full name": "a b c",
+	}
+	for key, expect := range tests {
+		if v, _ := c.GetValue("app", key); v != expect {
+			t.Errorf("%s: expect '%s', got '%s'", key, expect, v)
+		}
+	}
+	if out := saveString(t, c); out != "[app]\nname abc\nurl http://localhost:8080/?a=b\n`full name` a b c\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+
+	// Values which look like comments or quotes are quoted on save.
+	c = newConfigFile(nil)
+	c.InlineComment = true
+	c.SetValue("a", "k", "x ; y")
+	c.SetValue("a", "q", `"x"`)
+	cc := newConfigFile(nil)
+	cc.InlineComment = true
+	if err = cc.read(strings.NewReader(saveString(t, c))); err != nil {
+		t.Fatalf("read saved: %v", err)
+	}
+	if !cc.Equal(c) {
+		t.Errorf("saved: expect equal configuration, got\n%s", saveString(t, cc))
+	}
+
+	// Only "=" splits, ":" is part of the key.
+	c = newConfigFile(nil)
+	c.Delimiters = "="
+	if err = c.read(strings.NewReader("[app]\nhost:port = localhost:80\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.GetValue("app", "host:port"); v != "localhost:80" {
+		t.Errorf("host:port: expect 'localhost:80', got '%s'", v)
+	}
+}
+
+func Test_InheritsAnnotation(t *testing.T) {
+	const conf = "timeout = 30\n\n[common]\nhost = localhost\nport = 80\n\n" +
+		"[web] ; @inherits=common\nport = 8080\n\n[admin] # @inherits = web\n\n[other] ; just a comment\nname = abc\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	tests := []struct{ section, key, expect string }{
+		{"web", "port", "8080"},
+		{"web", "host", "localhost"},
+		{"web", "timeout", "30"},
+		{"admin", "port", "8080"},
+		{"admin", "host", "localhost"},
+		{"other", "name", "abc"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s' (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+	if _, err := c.GetValue("other", "host"); err == nil {
+		t.Error("other.host: expect key not found")
+	}
+	if comments := c.GetSectionComments("other"); comments != "; just a comment" {
+		t.Errorf("other comments: expect '; just a comment', got '%s'", comments)
+	}
+
+	expect := "timeout = 30\n\n[common]\nhost = localhost\nport = 80\n\n[web] ; @inherits=common\nport = 8080\n\n" +
+		"[admin] ; @inherits=web\n\n; just a comment\n[other]\nname = abc\n"
+	if out := saveString(t, c); out != expect {
+		t.Errorf("saved: expect\n%s\ngot\n%s", expect, out)
+	}
+
+	// Inheritance loop does not hang.
+	c.SetSectionParent("common", "admin")
+	if _, err := c.GetValue("web", "missing"); err == nil {
+		t.Error("web.missing: expect key not found")
+	}
+	c.SetSectionParent("web", "")
+	if _, err := c.GetValue("web", "host"); err == nil {
+		t.Error("web.host: expect key not found after parent removed")
+	}
+}
+
+func Test_CommentPrefixes(t *testing.T) {
+	const conf = "// App settings\n[app] // @inherits=base\n! Name\nname = abc\n; not a comment = 1\n[base]\nport = 80\n"
+
+	c := newConfigFile(nil)
+	c.CommentPrefixes = []string{"//", "!"}
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if comments := c.GetSectionComments("app"); comments != "// App settings" {
+		t.Errorf("app: unexpected comments %q", comments)
+	}
+	if comments := c.GetKeyComments("app", "name"); comments != "! Name" {
+		t.Errorf("app.name: unexpected comments %q", comments)
+	}
+	if v, _ := c.GetValue("app", "; not a comment"); v != "1" {
+		t.Errorf("app.; not a comment: expect '1', got '%s'", v)
+	}
+	if v, _ := c.GetValue("app", "port"); v != "80" {
+		t.Errorf("app.port: expect '80', got '%s'", v)
+	}
+
+	c.SetKeyComments("base", "port", "Port")
+	expect := "// App settings\n[app] // @inherits=base\n! Name\nname = abc\n; not a comment = 1\n\n[base]\n// Port\nport = 80\n"
+	if out := saveString(t, c); out != expect {
+		t.Errorf("saved: expect\n%s\ngot\n%s", expect, out)
+	}
+}
+
+func Test_InlineComment(t *testing.T) {
+	const conf = "[app]\n; Port\nport = 8080 ; the http port\nurl = http://a/#top # anchor\n" +
+		"color = #fff\nname = `a ; b` ; quoted\npath = a;b\nz = \"q # r\"\ntitle = \"a\" ; \"b\"\n"
+
+	c := newConfigFile(nil)
+	c.InlineComment = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	tests := []struct{ key, value, comments string }{
+		{"port", "8080", "; Port" + LineBreak + "; the http port"},
+		{"url", "http://a/#top", "# anchor"},
+		{"color", "#fff", ""},
+		{"name", "a ; b", "; quoted"},
+		{"path", "a;b", ""},
+		{"z", "q # r", ""},
+		{"title", "a", `; "b"`},
+	}
+	for _, test := range tests {
+		if v, _ := c.GetValue("app", test.key); v != test.value {
+			t.Errorf("%s: expect '%s', got '%s'", test.key, test.value, v)
+		}
+		if comments := c.GetKeyComments("app", test.key); comments != test.comments {
+			t.Errorf("%s: expect comments %q, got %q", test.key, test.comments, comments)
+		}
+	}
+
+	// Inline comments are part of value by default.
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.GetValue("app", "port"); v != "8080 ; the http port" {
+		t.Errorf("port: expect '8080 ; the http port', got '%s'", v)
+	}
+}
+
+func Test_AppendFiles(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\nport = 80\n")
+	override := writeFile(t, dir, "override.conf", "[app]\nport = 8080\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if err = c.AppendFiles(override, "?"+filepath.Join(dir, "missing.conf")); err != nil {
+		t.Fatalf("AppendFiles: %v", err)
+	}
+	if v := c.MustValue("app", "port"); v != "8080" {
+		t.Errorf("app.port: expect '8080', got '%s'", v)
+	}
+	if files := c.LoadedFiles(); len(files) != 2 || files[1] != override {
+		t.Errorf("LoadedFiles: expect [%s %s], got %v", name, override, files)
+	}
+
+	// Reload reads appended files too.
+	writeFile(t, dir, "override.conf", "[app]\nport = 9090\n")
+	if err = c.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if v := c.MustValue("app", "port"); v != "9090" {
+		t.Errorf("app.port: expect '9090' after reload, got '%s'", v)
+	}
+
+	if err = c.AppendFiles(filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("AppendFiles: expect error for missing file")
+	}
+}
+
+func Test_StrictDuplicates(t *testing.T) {
+	const conf = "[app]\nname = abc\n- = a\n- = b\n[db]\nname = db\n[app]\nname = def\n"
+
+	c := newConfigFile(nil)
+	c.StrictDuplicates = true
+	err := c.read(strings.NewReader(conf))
+	if e, ok := err.(ReadError); !ok || e.Reason != ERR_DUPLICATE_KEY || e.Content != "name = def" || e.Line != 8 {
+		t.Errorf("read: expect duplicate key error, got %v", err)
+	}
+	if err == nil || err.Error() != "line 8: duplicate key: name = def" {
+		t.Errorf("read: unexpected message %v", err)
+	}
+
+	// Last one wins by default.
+	c = newConfigFile(nil)
+	if err = c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v := c.MustValue("app", "name"); v != "def" {
+		t.Errorf("app.name: expect 'def', got '%s'", v)
+	}
+}
+
+func Test_MultiValues(t *testing.T) {
+	const conf = "[DEFAULT]\nport = 80\n[app]\nserver = a:%(port)s\nname = abc\nserver = b\n[app]\nserver = c\n"
+
+	c := newConfigFile(nil)
+	c.MultiValues = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v := c.MustValue("app", "server"); v != "c" {
+		t.Errorf("app.server: expect last value 'c', got '%s'", v)
+	}
+	values, err := c.GetValues("app", "server")
+	if err != nil || strings.Join(values, ",") != "a:80,b,c" {
+		t.Errorf("GetValues: expect [a:80 b c], got %v (%v)", values, err)
+	}
+	if v, err := c.GetValueAt("app", "server", -3); err != nil || v != "a:80" {
+		t.Errorf("GetValueAt(-3): expect 'a:80', got '%s' (%v)", v, err)
+	}
+	if values, _ = c.GetValues("app", "name"); len(values) != 1 || values[0] != "abc" {
+		t.Errorf("GetValues(name): expect [abc], got %v", values)
+	}
+
+	if out := saveString(t, c); out != "port = 80\n\n[app]\nserver = a:%(port)s\nserver = b\nserver = c\nname = abc\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+
+	// SetValue replaces every occurrence.
+	c.SetValue("app", "server", "d")
+	if values, _ = c.GetValues("app", "server"); len(values) != 1 || values[0] != "d" {
+		t.Errorf("GetValues: expect [d] after SetValue, got %v", values)
+	}
+
+	// Every occurrence is rewritten along with the value.
+	const repeated = "[a]\ns = x\ns = y\n"
+	c = newConfigFile(nil)
+	c.MultiValues = true
+	if err = c.read(strings.NewReader(repeated)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	c.TransformValues(func(section, key, value string) (string, bool) {
+		return " " + strings.ToUpper(value) + " ", true
+	})
+	c.Normalize(NormalizeOptions{TrimValues: true})
+	if values, _ = c.GetValues("a", "s"); strings.Join(values, ",") != "X,Y" || c.MustValue("a", "s") != "Y" {
+		t.Errorf("GetValues: expect [X Y] after TransformValues, got %v", values)
+	}
+	if out := saveString(t, c); out != "[a]\ns = X\ns = Y\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+
+	c = newConfigFile(nil)
+	c.MultiValues = true
+	c.ShardedLocks = true
+	if err = c.read(strings.NewReader(repeated)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	c.SetValue("a", "s", "3")
+	if values, _ = c.GetValues("a", "s"); len(values) != 1 || values[0] != "3" {
+		t.Errorf("GetValues: expect [3] after SetValue with ShardedLocks, got %v", values)
+	}
+	if out := saveString(t, c); out != "[a]\ns = 3\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+}
+
+func Test_IndentNesting(t *testing.T) {
+	const conf = "[app]\nname = abc\n; database\ndb =\n\thost = localhost\n\tpool:\n\t\tsize = 10\n\tport = 3306\n" +
+		"empty =\nport = 80\n[app.db]\nuser = root\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, err := c.GetValue("app", "size"); err != nil || v != "10" {
+		t.Errorf("app.size: expect '10' without IndentNesting, got '%s' (%v)", v, err)
+	}
+
+	c = newConfigFile(nil)
+	c.IndentNesting = true
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	tests := []struct{ section, key, expect string }{
+		{"app.db", "host", "localhost"},
+		{"app.db.pool", "size", "10"},
+		{"app.db", "port", "3306"},
+		{"app.db", "user", "root"},
+		{"app.db", "name", "abc"},
+		{"app", "empty", ""},
+		{"app", "port", "80"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect '%s', got '%s' (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+	if _, err := c.GetValue("app", "db"); err == nil {
+		t.Error("app.db: expect parent key not to be a key")
+	}
+	if comments := c.GetSectionComments("app.db"); comments != "; database" {
+		t.Errorf("app.db comments: expect '; database', got '%s'", comments)
+	}
+}
+
+func Test_LoadFromString(t *testing.T) {
+	const conf = "\xEF\xBB\xBFtop = 1\r\n" +
+		"; section comment\n" +
+		"[app]\r\n" +
+		"# key comment\n" +
+		"plain=  spaced value  \n" +
+		"colon: value\n" +
+		"empty =\n" +
+		"semicolon = a ; not a comment\n" +
+		"`quoted key` = `  kept  `\n" +
+		"\"a=b\" = \"\"\"with `backtick`\"\"\"\n" +
+		"- = first\n" +
+		"- = second\n" +
+		"text = \"\"\"line 1\n  line 2\"\"\"\n"
+
+	c, err := LoadFromString(conf)
+	if err != nil {
+		t.Fatalf("LoadFromString: %v", err)
+	}
+	tests := []struct{ section, key, expect string }{
+		{DEFAULT_SECTION, "top", "1"},
+		{"app", "plain", "spaced value"},
+		{"app", "colon", "value"},
+		{"app", "empty", ""},
+		{"app", "semicolon", "a ; not a comment"},
+		{"app", "quoted key", "  kept  "},
+		{"app", "a=b", "with `backtick`"},
+		{"app", "#1", "first"},
+		{"app", "#2", "second"},
+		{"app", "text", "line 1\n  line 2"},
+	}
+	for _, test := range tests {
+		if v, err := c.GetValue(test.section, test.key); err != nil || v != test.expect {
+			t.Errorf("%s.%s: expect %q, got %q (%v)", test.section, test.key, test.expect, v, err)
+		}
+	}
+	if comments := c.GetSectionComments("app"); comments != "; section comment" {
+		t.Errorf("app comments: expect '; section comment', got '%s'", comments)
+	}
+	if comments := c.GetKeyComments("app", "plain"); comments != "# key comment" {
+		t.Errorf("app.plain comments: expect '# key comment', got '%s'", comments)
+	}
+	if err = c.Reload(); err == nil {
+		t.Error("Reload: expect error for configuration without file")
+	}
+
+	if _, err = LoadFromString("[app]\nkey = `open\n"); err == nil {
+		t.Error("LoadFromString: expect error for unterminated quote")
+	}
+}
+
+func Test_ReadError(t *testing.T) {
+	tests := []struct {
+		conf    string
+		reason  ParseError
+		content string
+		line    int
+	}{
+		{"[app]\nname\n", ERR_COULD_NOT_PARSE, "name", 2},
+		{"\xEF\xBB\xBF[app]\n; comment\nkey = a \\\n  b\n\nname = `abc", ERR_COULD_NOT_PARSE, "name = `abc", 6},
+		{"[app]\nkey = 1\n[a]b]\n", ERR_INVALID_NAME, "[a]b]", 3},
+		{"[app]\n\n!include missing.conf\n", ERR_INCLUDE, "!include missing.conf", 3},
+	}
+	for _, test := range tests {
+		c := newConfigFile(nil)
+		c.LineContinuation = true
+		c.StrictNames = true
+		err := c.read(strings.NewReader(test.conf))
+		var e ReadError
+		if !errors.As(err, &e) || e.Reason != test.reason || e.Content != test.content || e.Line != test.line {
+			t.Errorf("read(%q): expect reason %d with '%s' at line %d, got %v", test.conf, test.reason, test.content, test.line, err)
+		}
+	}
+	if _, err := LoadFromBytes([]byte("[app]\nname\n")); err == nil || err.Error() != "line 2: could not parse line: name" {
+		t.Errorf("LoadFromBytes: unexpected message %v", err)
+	}
+	if _, err := LoadFromString("!include missing.conf\n"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadFromString: expect error of missing file, got %v", err)
+	}
+}
+
+func Test_LoadFromReader(t *testing.T) {
+	c, err := LoadFromReader(strings.NewReader("[app]\nname = abc\n"))
+	if err != nil {
+		t.Fatalf("LoadFromReader: %v", err)
+	}
+	if v := c.MustValue("app", "name"); v != "abc" {
+		t.Errorf("app.name: expect 'abc', got '%s'", v)
+	}
+	if err = c.Reload(); err != errNoFile {
+		t.Errorf("Reload: expect %v, got %v", errNoFile, err)
+	}
+
+	c, err = LoadFromBytes([]byte("[app]\nport = 80\n"))
+	if err != nil {
+		t.Fatalf("LoadFromBytes: %v", err)
+	}
+	if v := c.MustInt("app", "port"); v != 80 {
+		t.Errorf("app.port: expect 80, got %d", v)
+	}
+	if _, err = LoadFromBytes([]byte("[app]\nbroken line\n")); err == nil {
+		t.Error("LoadFromBytes: expect error for broken content")
+	}
+}
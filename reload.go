@@ -0,0 +1,281 @@
+package goconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeType is the kind of a Change.
+type ChangeType int
+
+const (
+	CHANGE_ADDED ChangeType = iota + 1
+	CHANGE_REMOVED
+	CHANGE_MODIFIED
+)
+
+// A Change represents a section-key whose value differs between two configurations.
+// OldValue is empty for added keys and NewValue is empty for removed keys.
+type Change struct {
+	Type     ChangeType
+	Section  string
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+// errNoFile occurs when reload a configuration which is not loaded from files.
+var errNoFile = errors.New("no config file to reload")
+
+// errStdinReload occurs when reload a configuration which is read from stdin.
+var errStdinReload = errors.New("config read from stdin can't be reloaded")
+
+// Reload reads files of the configuration again and replaces
+// current content with them.
+// Current content is kept if any file fails to load.
+func (c *ConfigFile) Reload() error {
+	_, err := c.reloadSwap(false)
+	return err
+}
+
+// OnReload registers fn to be called after every successful reload,
+// with snapshots of the configuration before and after it.
+// Snapshots are clones that are not shared with anything else,
+// fn is called outside the lock so it may use c freely.
+func (c *ConfigFile) OnReload(fn func(before, after *ConfigFile)) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+	c.reloadHooks = append(c.reloadHooks, fn)
+}
+
+// AutoReload starts a goroutine which checks modification time of files
+// of the configuration every interval, and calls Reload when any of them
+// changes. Reload errors are passed to OnReloadError if it is set.
+// It returns a function to stop checking, which waits for the goroutine
+// to exit and can be called more than once.
+func (c *ConfigFile) AutoReload(interval time.Duration) (stop func()) {
+	c.rlock()
+	fileNames := append([]string(nil), c.fileNames...)
+	c.runlock()
+
+	last := modTimes(fileNames)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+
+			current := modTimes(fileNames)
+			if current == last {
+				continue
+			}
+			last = current
+			if err := c.Reload(); err != nil && c.OnReloadError != nil {
+				c.OnReloadError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(quit) })
+		<-done
+	}
+}
+
+// modTimes returns modification time and size of files joined as a string,
+// missing files have zero time, so they change once created.
+func modTimes(fileNames []string) string {
+	times := make([]string, 0, len(fileNames))
+	for _, name := range fileNames {
+		if name == STDIN_FILE_NAME {
+			continue
+		}
+		var (
+			t    time.Time
+			size int64
+		)
+		if path, err := configPath(strings.TrimPrefix(name, "?")); err == nil {
+			if fi, err := os.Stat(path); err == nil {
+				t, size = fi.ModTime(), fi.Size()
+			}
+		}
+		times = append(times, fmt.Sprintf("%s/%d", t, size))
+	}
+	return strings.Join(times, ";")
+}
+
+// ReloadWithDiff reads files of the configuration again and replaces
+// current content with them, it returns changes of values with variables
+// substituted, in the order of sections and keys.
+// Current content is kept if any file fails to load.
+func (c *ConfigFile) ReloadWithDiff() ([]Change, error) {
+	return c.reloadSwap(true)
+}
+
+// reloadSwap reloads c and calls hooks registered by OnReload,
+// it returns changes only if diff is true.
+func (c *ConfigFile) reloadSwap(diff bool) ([]Change, error) {
+	tmp, err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.BlockMode {
+		c.lock.Lock()
+	}
+	var changes []Change
+	if diff {
+		changes = diffConfig(c, tmp)
+	}
+	hooks := c.reloadHooks
+	var before, after *ConfigFile
+	if len(hooks) > 0 {
+		before = c.clone()
+	}
+	c.swap(tmp)
+	if len(hooks) > 0 {
+		after = c.clone()
+	}
+	if c.BlockMode {
+		c.lock.Unlock()
+	}
+
+	for _, fn := range hooks {
+		fn(before, after)
+	}
+	return changes, nil
+}
+
+// HasDiverged reads files of the configuration again and reports whether
+// they differ from current content as Equal does, e.g. to avoid overwriting
+// external changes when saving. Current content is never changed.
+// It returns the error if any file fails to load.
+func (c *ConfigFile) HasDiverged() (bool, error) {
+	tmp, err := c.reload()
+	if err != nil {
+		return false, err
+	}
+	return !c.Equal(tmp), nil
+}
+
+// reload reads files of c into a new configuration with the same options.
+func (c *ConfigFile) reload() (*ConfigFile, error) {
+	c.rlock()
+	if len(c.fileNames) == 0 {
+		c.runlock()
+		return nil, errNoFile
+	}
+	for _, name := range c.fileNames {
+		if name == STDIN_FILE_NAME {
+			c.runlock()
+			return nil, errStdinReload
+		}
+	}
+	tmp := newConfigFile(append([]string(nil), c.fileNames...))
+	tmp.copyOptions(c)
+	c.runlock()
+
+	for _, name := range tmp.fileNames {
+		if err := tmp.loadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	return tmp, nil
+}
+
+// swap replaces content of c with content of tmp,
+// the caller must hold the write lock.
+func (c *ConfigFile) swap(tmp *ConfigFile) {
+	c.loadedFiles = tmp.loadedFiles
+	c.data = tmp.data
+	c.sectionList = tmp.sectionList
+	c.keyList = tmp.keyList
+	c.sectionComments = tmp.sectionComments
+	c.sectionParents = tmp.sectionParents
+	c.keyComments = tmp.keyComments
+	c.keyTypes = tmp.keyTypes
+	c.keyQuotes = tmp.keyQuotes
+	c.keyValues = tmp.keyValues
+	c.footerComments = tmp.footerComments
+	c.sectionLocks = tmp.sectionLocks
+	c.ClearFileRefs()
+}
+
+// Diff compares c with other after substitution, and returns keys of
+// every section which are added to, removed from or changed in c
+// relative to other, keys are in their order.
+func (c *ConfigFile) Diff(other *ConfigFile) (added, removed, changed map[string][]string) {
+	added = make(map[string][]string)
+	removed = make(map[string][]string)
+	changed = make(map[string][]string)
+	if other == c {
+		return added, removed, changed
+	}
+
+	// Take a snapshot first, so c and other are never locked together.
+	other.rlock()
+	o := other.clone()
+	other.runlock()
+
+	c.rlock()
+	defer c.runlock()
+
+	for _, change := range diffConfig(o, c) {
+		switch change.Type {
+		case CHANGE_ADDED:
+			added[change.Section] = append(added[change.Section], change.Key)
+		case CHANGE_REMOVED:
+			removed[change.Section] = append(removed[change.Section], change.Key)
+		case CHANGE_MODIFIED:
+			changed[change.Section] = append(changed[change.Section], change.Key)
+		}
+	}
+	return added, removed, changed
+}
+
+// diffConfig returns changes from a to b, the caller must hold read locks of both.
+func diffConfig(a, b *ConfigFile) []Change {
+	av, bv := a.resolved(), b.resolved()
+
+	var changes []Change
+	for _, section := range b.sectionList {
+		for _, key := range b.keyList[section] {
+			newValue, ok := bv[section][key]
+			if !ok {
+				continue
+			}
+			if oldValue, ok := av[section][key]; !ok {
+				changes = append(changes, Change{CHANGE_ADDED, section, key, "", newValue})
+			} else if oldValue != newValue {
+				changes = append(changes, Change{CHANGE_MODIFIED, section, key, oldValue, newValue})
+			}
+		}
+	}
+	for _, section := range a.sectionList {
+		for _, key := range a.keyList[section] {
+			oldValue, ok := av[section][key]
+			if !ok {
+				continue
+			}
+			if _, ok = bv[section][key]; !ok {
+				changes = append(changes, Change{CHANGE_REMOVED, section, key, oldValue, ""})
+			}
+		}
+	}
+	return changes
+}
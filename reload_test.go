@@ -0,0 +1,242 @@
+package goconfig
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_ReloadWithDiff(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\nversion = 1.0\nport = 80\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+
+	writeFile(t, dir, "app.conf", "[app]\nname = abc\nversion = 2.0\n[db]\nhost = localhost\n")
+	changes, err := c.ReloadWithDiff()
+	if err != nil {
+		t.Fatalf("ReloadWithDiff: %v", err)
+	}
+	expect := []Change{
+		{CHANGE_MODIFIED, "app", "version", "1.0", "2.0"},
+		{CHANGE_ADDED, "db", "host", "", "localhost"},
+		{CHANGE_REMOVED, "app", "port", "80", ""},
+	}
+	if len(changes) != len(expect) {
+		t.Fatalf("ReloadWithDiff: expect %v, got %v", expect, changes)
+	}
+	for i := range expect {
+		if changes[i] != expect[i] {
+			t.Errorf("ReloadWithDiff: expect %v, got %v", expect[i], changes[i])
+		}
+	}
+	if v, _ := c.GetValue("db", "host"); v != "localhost" {
+		t.Errorf("db.host: expect 'localhost', got '%s'", v)
+	}
+
+	// Broken file keeps current content.
+	writeFile(t, dir, "app.conf", "[app]\nbroken line\n")
+	if _, err = c.ReloadWithDiff(); err == nil {
+		t.Error("ReloadWithDiff: expect error for broken file")
+	}
+	if v, _ := c.GetValue("app", "version"); v != "2.0" {
+		t.Errorf("app.version: expect '2.0', got '%s'", v)
+	}
+
+	if _, err = newConfigFile(nil).ReloadWithDiff(); err != errNoFile {
+		t.Errorf("ReloadWithDiff: expect errNoFile, got %v", err)
+	}
+}
+
+func Test_Reload(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "; App\n[app]\nname = abc\nport = 80\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+
+	writeFile(t, dir, "app.conf", "[app]\nname = def\n")
+	if err = c.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if v, _ := c.GetValue("app", "name"); v != "def" {
+		t.Errorf("app.name: expect 'def', got '%s'", v)
+	}
+	if _, err = c.GetValue("app", "port"); err == nil {
+		t.Error("app.port: expect key not found after reload")
+	}
+	if comments := c.sectionComments["app"]; comments != "" {
+		t.Errorf("app comments: expect empty, got '%s'", comments)
+	}
+
+	// Broken file keeps current content.
+	writeFile(t, dir, "app.conf", "[app]\nbroken line\n")
+	if err = c.Reload(); err == nil {
+		t.Error("Reload: expect error for broken file")
+	}
+	if v, _ := c.GetValue("app", "name"); v != "def" {
+		t.Errorf("app.name: expect 'def' after failed reload, got '%s'", v)
+	}
+
+	if err = newConfigFile(nil).Reload(); err != errNoFile {
+		t.Errorf("Reload: expect %v, got %v", errNoFile, err)
+	}
+}
+
+func Test_AutoReload(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	errs := make(chan error, 10)
+	c.OnReloadError = func(err error) { errs <- err }
+
+	stop := c.AutoReload(10 * time.Millisecond)
+	defer stop()
+
+	writeFile(t, dir, "app.conf", "[app]\nname = abcdef\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for c.MustValue("app", "name") != "abcdef" {
+		if time.Now().After(deadline) {
+			t.Fatal("app.name: expect 'abcdef' after auto reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	writeFile(t, dir, "app.conf", "[app]\nbroken line\n")
+	select {
+	case err = <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnReloadError: expect reload error")
+	}
+	if v := c.MustValue("app", "name"); v != "abcdef" {
+		t.Errorf("app.name: expect 'abcdef' after failed reload, got '%s'", v)
+	}
+
+	stop()
+	stop()
+}
+
+func Test_OnReload(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	var calls int
+	c.OnReload(func(before, after *ConfigFile) {
+		calls++
+		if v := before.MustValue("app", "name"); v != "abc" {
+			t.Errorf("before: expect 'abc', got '%s'", v)
+		}
+		if v := after.MustValue("app", "name"); v != "def" {
+			t.Errorf("after: expect 'def', got '%s'", v)
+		}
+		// Snapshots are not shared with c, and c is not locked.
+		after.SetValue("app", "name", "changed")
+		c.SetValue("app", "hooked", "true")
+	})
+
+	writeFile(t, dir, "app.conf", "[app]\nname = def\n")
+	if err = c.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("OnReload: expect 1 call, got %d", calls)
+	}
+	if v := c.MustValue("app", "name"); v != "def" {
+		t.Errorf("app.name: expect 'def', got '%s'", v)
+	}
+	if !c.MustBool("app", "hooked") {
+		t.Error("app.hooked: expect true")
+	}
+
+	// Failed reload does not call hooks.
+	writeFile(t, dir, "app.conf", "[app]\nbroken line\n")
+	c.Reload()
+	if calls != 1 {
+		t.Errorf("OnReload: expect no call for failed reload, got %d calls", calls)
+	}
+}
+
+func Test_HasDiverged(t *testing.T) {
+	dir := t.TempDir()
+	name := writeFile(t, dir, "app.conf", "[app]\nname = abc\nversion = 1.0\n")
+
+	c, err := LoadConfigFile(name)
+	if err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	if diverged, err := c.HasDiverged(); err != nil || diverged {
+		t.Errorf("HasDiverged: expect false, got %v (%v)", diverged, err)
+	}
+
+	// Order and comments are ignored.
+	writeFile(t, dir, "app.conf", "; App\n[app]\nversion = 1.0\nname = abc\n")
+	if diverged, _ := c.HasDiverged(); diverged {
+		t.Error("HasDiverged: expect false for reordered keys")
+	}
+
+	writeFile(t, dir, "app.conf", "[app]\nname = abc\nversion = 2.0\n")
+	if diverged, _ := c.HasDiverged(); !diverged {
+		t.Error("HasDiverged: expect true for changed value")
+	}
+	if v := c.MustValue("app", "version"); v != "1.0" {
+		t.Errorf("app.version: expect '1.0', got '%s'", v)
+	}
+
+	c.SetValue("app", "version", "2.0")
+	if diverged, _ := c.HasDiverged(); diverged {
+		t.Error("HasDiverged: expect false after same change in memory")
+	}
+	c.SetValue("db", "host", "localhost")
+	if diverged, _ := c.HasDiverged(); !diverged {
+		t.Error("HasDiverged: expect true for new section")
+	}
+
+	writeFile(t, dir, "app.conf", "[app]\nbroken line\n")
+	if _, err = c.HasDiverged(); err == nil {
+		t.Error("HasDiverged: expect error for broken file")
+	}
+}
+
+func Test_Diff(t *testing.T) {
+	old := newConfigFile(nil)
+	old.SetValue(DEFAULT_SECTION, "host", "localhost")
+	old.SetValue("app", "url", "http://%(host)s/")
+	old.SetValue("app", "name", "abc")
+	old.SetValue("db", "port", "3306")
+
+	c := old.Clone()
+	c.SetValue(DEFAULT_SECTION, "host", "example.com")
+	c.DeleteKey("app", "name")
+	c.SetValue("app", "debug", "true")
+	c.SetValue("cache", "size", "10")
+	c.SetValue("cache", "ttl", "60")
+
+	added, removed, changed := c.Diff(old)
+	if fmt.Sprint(added) != "map[app:[debug] cache:[size ttl]]" {
+		t.Errorf("added: unexpected result %v", added)
+	}
+	if fmt.Sprint(removed) != "map[app:[name]]" {
+		t.Errorf("removed: unexpected result %v", removed)
+	}
+	// Value of app.url changes by substitution.
+	if fmt.Sprint(changed) != "map[DEFAULT:[host] app:[url]]" {
+		t.Errorf("changed: unexpected result %v", changed)
+	}
+
+	if added, removed, changed = c.Diff(c); len(added)+len(removed)+len(changed) != 0 {
+		t.Errorf("Diff(c): expect no difference, got %v %v %v", added, removed, changed)
+	}
+}
@@ -0,0 +1,138 @@
+package goconfig
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// MapTo fills exported fields of struct pointed by v with values of keys
+// in the given section. Key name is given by `conf:"key"` tag of the field,
+// or the field name if it has no tag, and tag "-" skips the field.
+// Supported field types are string, bool, int, int64, float64 and
+// time.Duration. Fields whose key does not exist are left unchanged.
+func (c *ConfigFile) MapTo(section string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("MapTo: v must be a non-nil pointer to struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" { // Unexported.
+			continue
+		}
+		key := field.Tag.Get("conf")
+		if key == "-" {
+			continue
+		}
+		if len(key) == 0 {
+			key = field.Name
+		}
+
+		value, err := c.getValue(section, key)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			return err
+		}
+		if err = setField(rv.Field(i), value); err != nil {
+			return fmt.Errorf("field '%s': %v", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// setField converts value to the type of field and sets it.
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// ReflectFrom sets exported fields of struct v, or struct pointed by v,
+// as keys in the given section, it is the reverse of MapTo.
+// Key names are given the same way as MapTo and values are formatted
+// by fmt. Nested struct field is set in sub-section "section.key",
+// or "key" for DEFAULT section.
+func (c *ConfigFile) ReflectFrom(section string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("ReflectFrom: v must be a struct or a non-nil pointer to struct")
+	}
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" { // Unexported.
+			continue
+		}
+		key := field.Tag.Get("conf")
+		if key == "-" {
+			continue
+		}
+		if len(key) == 0 {
+			key = field.Name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			sub := section + "." + key
+			// Sub-section of DEFAULT section is named by the key only.
+			if len(section) == 0 || section == DEFAULT_SECTION {
+				sub = key
+			}
+			if err := c.ReflectFrom(sub, rv.Field(i).Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		if c.StrictNames {
+			if err := CheckKeyName(key, false); err != nil {
+				return err
+			}
+		}
+		c.SetValue(section, key, fmt.Sprint(rv.Field(i).Interface()))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package goconfig
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MapTo(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("[server]\nhost = localhost\nport = 8080\ndebug = true\n" +
+		"ratio = 0.5\ntimeout = 30s\nName = abc\nskipped = x\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	var s struct {
+		Host    string        `conf:"host"`
+		Port    int           `conf:"port"`
+		Debug   bool          `conf:"debug"`
+		Ratio   float64       `conf:"ratio"`
+		Timeout time.Duration `conf:"timeout"`
+		Name    string
+		Skipped string `conf:"-"`
+		Missing int64  `conf:"missing"`
+		private string `conf:"host"`
+	}
+	s.Missing = 42
+	if err = c.MapTo("server", &s); err != nil {
+		t.Fatalf("MapTo: %v", err)
+	}
+	if s.Host != "localhost" || s.Port != 8080 || !s.Debug || s.Ratio != 0.5 ||
+		s.Timeout != 30*time.Second || s.Name != "abc" {
+		t.Errorf("MapTo: unexpected result %+v", s)
+	}
+	if s.Skipped != "" || s.Missing != 42 || s.private != "" {
+		t.Errorf("MapTo: expect skipped fields unchanged, got %+v", s)
+	}
+
+	var bad struct {
+		Hosts []string `conf:"host"`
+	}
+	if err = c.MapTo("server", &bad); err == nil || !strings.Contains(err.Error(), "Hosts") {
+		t.Errorf("MapTo: expect error naming field Hosts, got %v", err)
+	}
+	var wrong struct {
+		Port bool `conf:"port"`
+	}
+	if err = c.MapTo("server", &wrong); err == nil {
+		t.Error("MapTo: expect error for invalid bool")
+	}
+	if err = c.MapTo("server", s); err == nil {
+		t.Error("MapTo: expect error for non-pointer")
+	}
+	if err = c.MapTo("missing", &s); err == nil {
+		t.Error("MapTo: expect error for missing section")
+	}
+}
+
+func Test_ReflectFrom(t *testing.T) {
+	type DB struct {
+		Host string `conf:"host"`
+		Port int    `conf:"port"`
+	}
+	defaults := struct {
+		Name    string        `conf:"name"`
+		Debug   bool          `conf:"debug"`
+		Timeout time.Duration `conf:"timeout"`
+		Ratio   float64
+		Skipped string `conf:"-"`
+		DB      DB     `conf:"db"`
+		private string
+	}{"abc", true, 30 * time.Second, 0.5, "x", DB{"localhost", 3306}, "y"}
+
+	c := newConfigFile(nil)
+	if err := c.ReflectFrom("app", &defaults); err != nil {
+		t.Fatalf("ReflectFrom: %v", err)
+	}
+	if out := saveString(t, c); out != "[app]\nname = abc\ndebug = true\ntimeout = 30s\nRatio = 0.5\n\n"+
+		"[app.db]\nhost = localhost\nport = 3306\n" {
+		t.Errorf("saved: unexpected result %q", out)
+	}
+
+	// It reads back with MapTo.
+	got := defaults
+	got.Name, got.Timeout, got.DB.Port = "", 0, 0
+	if err := c.MapTo("app", &got); err != nil {
+		t.Fatalf("MapTo: %v", err)
+	}
+	if got.Name != "abc" || got.Timeout != 30*time.Second {
+		t.Errorf("MapTo: unexpected result %+v", got)
+	}
+	if port := c.MustInt("app.db", "port"); port != 3306 {
+		t.Errorf("app.db.port: expect 3306, got %d", port)
+	}
+
+	// Sub-section of DEFAULT section has no leading dot.
+	for _, section := range []string{"", DEFAULT_SECTION} {
+		c = newConfigFile(nil)
+		if err := c.ReflectFrom(section, &defaults); err != nil {
+			t.Fatalf("ReflectFrom: %v", err)
+		}
+		if port := c.MustInt("db", "port"); port != 3306 || len(c.sectionList) != 2 {
+			t.Errorf("ReflectFrom(%q): expect sections [DEFAULT db], got %v", section, c.sectionList)
+		}
+	}
+
+	if err := c.ReflectFrom("app", "string"); err == nil {
+		t.Error("ReflectFrom: expect error for non-struct")
+	}
+}
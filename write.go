@@ -0,0 +1,294 @@
+package goconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyValueSpacing controls the spaces written around key-value delimiter.
+type KeyValueSpacing int
+
+const (
+	// Write "key = value", the default.
+	SPACING_SINGLE KeyValueSpacing = iota
+	// Write "key=value".
+	SPACING_NONE
+)
+
+// SaveConfigFile writes configuration file to local file system.
+func SaveConfigFile(c *ConfigFile, filename string) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteTo writes INI format of the configuration to w,
+// it returns the number of bytes written.
+func (c *ConfigFile) WriteTo(w io.Writer) (int64, error) {
+	c.rlock()
+	defer c.runlock()
+
+	delims := c.delimiters()
+	equalSign := " " + delims[:1] + " "
+	if c.KeyValueSpacing == SPACING_NONE || strings.TrimSpace(delims[:1]) == "" {
+		equalSign = delims[:1]
+	}
+
+	// DEFAULT section has no header, so it must go first.
+	sections := make([]string, 0, len(c.sectionList))
+	if _, ok := c.data[DEFAULT_SECTION]; ok || len(c.sectionComments[DEFAULT_SECTION]) > 0 {
+		sections = append(sections, DEFAULT_SECTION)
+	}
+	for _, section := range c.sectionList {
+		if section != DEFAULT_SECTION {
+			sections = append(sections, section)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	// Whether an empty line is needed to separate from what is written so far.
+	needBlank := func() bool {
+		return buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte(LineBreak+LineBreak))
+	}
+	for _, section := range sections {
+		// Put a line between sections.
+		if needBlank() {
+			buf.WriteString(LineBreak)
+		}
+		// Write section comments.
+		if comments := c.sectionComments[section]; len(comments) > 0 {
+			buf.WriteString(comments + LineBreak)
+			// Empty line keeps comments of DEFAULT section apart from its first key.
+			if section == DEFAULT_SECTION {
+				buf.WriteString(LineBreak)
+			}
+		}
+		if section != DEFAULT_SECTION {
+			buf.WriteString("[" + quoteSection(section) + "]")
+			if parent, ok := c.sectionParents[section]; ok {
+				buf.WriteString(" " + c.commentPrefixes()[0] + " " + INHERITS_ANNOTATION + "=" + parent)
+			}
+			buf.WriteString(LineBreak)
+		}
+
+		for _, key := range c.keyList[section] {
+			// Write key comments.
+			if comments := c.keyComments[section][key]; len(comments) > 0 {
+				buf.WriteString(comments + LineBreak)
+			}
+
+			keyName := key
+			// Check if it's auto increment.
+			if isAutoIncrement(keyName) {
+				keyName = "-"
+			} else {
+				keyName = quoteKey(keyName, delims, c.commentPrefixes())
+			}
+			if typ := c.keyTypes[section][key]; c.TypeAnnotations && len(typ) > 0 {
+				keyName += "<" + typ + ">"
+			}
+			values, ok := c.keyValues[section][key]
+			if !ok {
+				values = []string{c.data[section][key]}
+			}
+			for _, value := range values {
+				// Keep the quote style of the original file.
+				if quote := c.keyQuotes[section][key]; len(quote) > 0 {
+					value = quote + value + quote
+				} else {
+					// Value which looks like a quoted one or an inline comment
+					// needs quotes, see InlineComment.
+					force := c.InlineComment && (strings.HasPrefix(value, `"`) || c.inlineCommentIndex(value) > -1)
+					value = quoteValue(value, force)
+				}
+				buf.WriteString(keyName + equalSign + value + LineBreak)
+			}
+		}
+	}
+
+	// Write footer comments.
+	if len(c.footerComments) > 0 {
+		if needBlank() {
+			buf.WriteString(LineBreak)
+		}
+		buf.WriteString(c.footerComments + LineBreak)
+	}
+	return buf.WriteTo(w)
+}
+
+// ExportJSON returns the configuration as a JSON object, which maps
+// every section name, including DEFAULT, to an object of its keys with
+// variables substituted. Sections and keys are in their order.
+// It returns the error of the first value which fails to resolve.
+func (c *ConfigFile) ExportJSON() ([]byte, error) {
+	c.rlock()
+	defer c.runlock()
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, section := range c.sectionList {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(section)
+		buf.Write(name)
+		buf.WriteString(":{")
+		for j, key := range c.keyList[section] {
+			value, err := c.get(context.Background(), section, key, nil)
+			if err != nil {
+				return nil, err
+			}
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ = json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			data, _ := json.Marshal(value)
+			buf.Write(data)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ExportMap returns the configuration as a nested map, it is the same
+// as Resolved and the counterpart of ExportJSON. The map is a deep copy,
+// so it is safe to change.
+func (c *ConfigFile) ExportMap() map[string]map[string]string {
+	return c.Resolved()
+}
+
+// quoteSection wraps section name with double quotes if it could not be
+// read back as is, e.g. it has ']' or leading or trailing spaces.
+func quoteSection(name string) string {
+	if name == strings.TrimSpace(name) && !strings.ContainsAny(name, "[]") &&
+		!(strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`)) {
+		return name
+	}
+	return `"` + name + `"`
+}
+
+// isAutoIncrement reports whether key is generated for "-", e.g. "#1".
+func isAutoIncrement(key string) bool {
+	if len(key) < 2 || key[0] != '#' {
+		return false
+	}
+	for _, r := range key[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteKey wraps key name with quotes if it could not be read back as is
+// with delimiters delims and comment prefixes, e.g. it would be read as
+// a comment, a section header or an include.
+func quoteKey(key, delims string, prefixes []string) string {
+	plain := len(key) > 0 && !strings.ContainsAny(key, delims) && key == strings.TrimSpace(key) &&
+		key[0] != '"' && key[0] != '`' && key[0] != '[' &&
+		!strings.HasPrefix(key, "!include") && !strings.HasPrefix(key, "@import")
+	for _, prefix := range prefixes {
+		if len(prefix) > 0 && strings.HasPrefix(key, prefix) {
+			plain = false
+		}
+	}
+	if plain {
+		return key
+	}
+
+	switch {
+	case !strings.Contains(key, "`"):
+		return "`" + key + "`"
+	case !strings.Contains(key, `"`):
+		return `"` + key + `"`
+	}
+	return `"""` + key + `"""`
+}
+
+// quoteValue wraps value with quotes if it could not be read back as is
+// or force is true, value with line breaks is wrapped with triple quotes.
+func quoteValue(value string, force bool) string {
+	if !force && value == strings.TrimSpace(value) && !strings.ContainsAny(value, "\r\n") &&
+		!strings.HasSuffix(value, `\`) &&
+		!strings.HasPrefix(value, "`") && !strings.HasPrefix(value, `"""`) {
+		return value
+	}
+
+	if !strings.ContainsAny(value, "`\r\n") {
+		return "`" + value + "`"
+	}
+	return `"""` + value + `"""`
+}
+
+// A ConfigWriter writes INI format to an io.Writer in call order,
+// so huge configurations can be generated with constant memory.
+// It does no deduplication or validation of sections and keys.
+type ConfigWriter struct {
+	w       io.Writer
+	written bool // Indicates whether anything has been written.
+
+	// KeyValueSpacing controls spaces around delimiter.
+	KeyValueSpacing KeyValueSpacing
+}
+
+// NewWriter returns a new ConfigWriter writing to w.
+func NewWriter(w io.Writer) *ConfigWriter {
+	return &ConfigWriter{w: w}
+}
+
+// Section writes a section header, with a blank line before it
+// if it's not at the beginning.
+func (cw *ConfigWriter) Section(name string) error {
+	header := "[" + quoteSection(name) + "]" + LineBreak
+	if cw.written {
+		header = LineBreak + header
+	}
+	return cw.write(header)
+}
+
+// Comment writes comments, every line is prefixed with "; "
+// if it does not start with '#' or ';'.
+func (cw *ConfigWriter) Comment(text string) error {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if len(line) == 0 || (line[0] != '#' && line[0] != ';') {
+			line = "; " + line
+		}
+		lines[i] = line
+	}
+	return cw.write(strings.Join(lines, LineBreak) + LineBreak)
+}
+
+// Key writes a key-value, with quotes added if needed.
+// It returns an error if name is empty.
+func (cw *ConfigWriter) Key(name, value string) error {
+	if len(name) == 0 {
+		return errors.New("empty key name")
+	}
+	equalSign := " = "
+	if cw.KeyValueSpacing == SPACING_NONE {
+		equalSign = "="
+	}
+	return cw.write(quoteKey(name, "=:", []string{";", "#"}) + equalSign + quoteValue(value, false) + LineBreak)
+}
+
+func (cw *ConfigWriter) write(s string) error {
+	cw.written = true
+	_, err := io.WriteString(cw.w, s)
+	return err
+}
@@ -0,0 +1,279 @@
+package goconfig
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// saveString saves c into a temporary file and returns its content.
+func saveString(t *testing.T, c *ConfigFile) string {
+	name := filepath.Join(t.TempDir(), "save.conf")
+	if err := SaveConfigFile(c, name); err != nil {
+		t.Fatalf("SaveConfigFile: %v", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return strings.Replace(string(data), LineBreak, "\n", -1)
+}
+
+func Test_SaveConfigFile(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+	c.SetValue("", "key:with=delim", " spaced ")
+	c.SetSectionComments("app", "app comments")
+	c.SetKeyComments("app", "name", "# name comments")
+
+	expect := "`key:with=delim` = ` spaced `\n\n" +
+		"; app comments\n[app]\n# name comments\nname = abc\n"
+	if s := saveString(t, c); s != expect {
+		t.Errorf("SaveConfigFile: expect\n%s\ngot\n%s", expect, s)
+	}
+
+	// Saved file must read back the same.
+	c2 := newConfigFile(nil)
+	if err := c2.read(strings.NewReader(expect)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c2.getValue("", "key:with=delim"); v != " spaced " {
+		t.Errorf("DEFAULT.key:with=delim: expect ' spaced ', got '%s'", v)
+	}
+
+	// Keys which look like comments, headers or includes are quoted,
+	// only generated keys are written as "-".
+	c = newConfigFile(nil)
+	if err := c.read(strings.NewReader("[app]\n- = auto\n")); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	keys := []string{";k", "#k", "[k]", "!include", "@import x", "#12a"}
+	for _, key := range keys {
+		c.SetValue("app", key, "v")
+	}
+	c2 = newConfigFile(nil)
+	if err := c2.read(strings.NewReader(saveString(t, c))); err != nil {
+		t.Fatalf("read saved: %v", err)
+	}
+	if !c2.Equal(c) {
+		t.Errorf("saved: expect equal configuration, got\n%s", saveString(t, c2))
+	}
+}
+
+func Test_KeyValueSpacing(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+
+	for spacing, expect := range map[KeyValueSpacing]string{
+		SPACING_SINGLE: "[app]\nname = abc\n",
+		SPACING_NONE:   "[app]\nname=abc\n",
+	} {
+		c.KeyValueSpacing = spacing
+		if s := saveString(t, c); s != expect {
+			t.Errorf("KeyValueSpacing(%d): expect %q, got %q", spacing, expect, s)
+		}
+	}
+}
+
+func Test_SaveQuoteStyles(t *testing.T) {
+	const conf = "[app]\nplain = abc\nbacktick = `abc`\ntriple = \"\"\"a`b\"\"\"\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if s := saveString(t, c); s != conf {
+		t.Errorf("SaveConfigFile: expect\n%s\ngot\n%s", conf, s)
+	}
+
+	// Programmatic value gets quotes by its content.
+	c.SetValue("app", "set", "`x")
+	if s := saveString(t, c); !strings.HasSuffix(s, "set = \"\"\"`x\"\"\"\n") {
+		t.Errorf("SaveConfigFile: unexpected quotes of programmatic value\n%s", s)
+	}
+
+	// Quote of the file is dropped when the value is set again.
+	for _, sharded := range []bool{false, true} {
+		c = newConfigFile(nil)
+		c.ShardedLocks = sharded
+		if err := c.read(strings.NewReader(conf)); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		c.SetValue("app", "backtick", "line1\nline2")
+		cc, err := LoadFromString(saveString(t, c))
+		if err != nil {
+			t.Fatalf("ShardedLocks %v: read saved: %v", sharded, err)
+		}
+		if v := cc.MustValue("app", "backtick"); v != "line1\nline2" {
+			t.Errorf("ShardedLocks %v: expect 'line1\\nline2', got '%s'", sharded, v)
+		}
+	}
+}
+
+func Test_Normalize(t *testing.T) {
+	c := newConfigFile(nil)
+	err := c.read(strings.NewReader("[b]\nz = ` 1 `\n; y comments\ny = 2\n; a comments\n[a]\nx = 3\n\n; footer\n"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	c.SetValue("a", "w", " 4 ")
+
+	// Quoted value keeps its spaces.
+	c.Normalize(NormalizeOptions{SortSections: true, SortKeys: true, CommentChar: "#", TrimValues: true})
+	expect := "# a comments\n[a]\nw = 4\nx = 3\n\n[b]\n# y comments\ny = 2\nz = ` 1 `\n\n# footer\n"
+	if s := saveString(t, c); s != expect {
+		t.Errorf("Normalize: expect\n%s\ngot\n%s", expect, s)
+	}
+}
+
+func Test_WriteTo(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue("app", "name", "abc")
+
+	var buf bytes.Buffer
+	n, err := c.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	expect := "[app]" + LineBreak + "name = abc" + LineBreak
+	if buf.String() != expect || n != int64(len(expect)) {
+		t.Errorf("WriteTo: expect %q (%d bytes), got %q (%d bytes)", expect, len(expect), buf.String(), n)
+	}
+}
+
+func Test_ExportJSON(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "url", "http://%(host)s/")
+	c.SetValue("app", "name", `"abc"`)
+	c.SetValue("db", "port", "3306")
+
+	data, err := c.ExportJSON()
+	if err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	expect := `{"DEFAULT":{"host":"localhost"},"app":{"url":"http://localhost/","name":"\"abc\""},"db":{"port":"3306"}}`
+	if string(data) != expect {
+		t.Errorf("ExportJSON: expect\n%s\ngot\n%s", expect, data)
+	}
+
+	c.StrictVars = true
+	c.SetValue("db", "user", "%(password)s")
+	if _, err = c.ExportJSON(); err == nil {
+		t.Error("ExportJSON: expect error for undefined variable")
+	}
+}
+
+func Test_ExportMap(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "url", "http://%(host)s/")
+	c.addSection("empty")
+
+	m := c.ExportMap()
+	if len(m) != 3 || len(m["empty"]) != 0 || m["app"]["url"] != "http://localhost/" {
+		t.Errorf("ExportMap: unexpected result %v", m)
+	}
+	m["app"]["url"] = "changed"
+	m["db"] = map[string]string{"port": "3306"}
+	if v := c.MustValue("app", "url"); v != "http://localhost/" {
+		t.Errorf("app.url: expect 'http://localhost/', got '%s'", v)
+	}
+	if _, err := c.GetSection("db"); err == nil {
+		t.Error("GetSection(db): expect error for missing section")
+	}
+}
+
+func Test_SaveComments(t *testing.T) {
+	c := newConfigFile(nil)
+	c.SetValue(DEFAULT_SECTION, "host", "localhost")
+	c.SetValue("app", "name", "abc")
+	c.SetValue("app", "port", "80")
+	c.SetValue("db", "user", "root")
+	c.SetSectionComments(DEFAULT_SECTION, "defaults")
+	c.SetKeyComments(DEFAULT_SECTION, "host", "host of all")
+	c.SetSectionComments("app", "application\n# more")
+	c.SetKeyComments("app", "port", "# listen port")
+	c.SetSectionComments("db", "database")
+	c.SetKeyComments("db", "user", "user name")
+
+	const expect = "; defaults\n\n; host of all\nhost = localhost\n\n" +
+		"; application\n# more\n[app]\nname = abc\n# listen port\nport = 80\n\n" +
+		"; database\n[db]\n; user name\nuser = root\n"
+	if out := saveString(t, c); out != expect {
+		t.Errorf("saved: expect\n%s\ngot\n%s", expect, out)
+	}
+
+	// It reads back with comments in place.
+	cc := newConfigFile(nil)
+	if err := cc.read(strings.NewReader(expect)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if out := saveString(t, cc); out != expect {
+		t.Errorf("saved again: expect\n%s\ngot\n%s", expect, out)
+	}
+	if comments := cc.GetSectionComments(DEFAULT_SECTION); comments != "; defaults" {
+		t.Errorf("DEFAULT comments: expect '; defaults', got '%s'", comments)
+	}
+	if comments := cc.GetKeyComments(DEFAULT_SECTION, "host"); comments != "; host of all" {
+		t.Errorf("host comments: expect '; host of all', got '%s'", comments)
+	}
+
+	// DEFAULT section with only comments.
+	c = newConfigFile(nil)
+	c.SetSectionComments(DEFAULT_SECTION, "defaults")
+	c.SetValue("app", "name", "abc")
+	const only = "; defaults\n\n[app]\nname = abc\n"
+	if out := saveString(t, c); out != only {
+		t.Errorf("saved: expect\n%s\ngot\n%s", only, out)
+	}
+	cc = newConfigFile(nil)
+	if err := cc.read(strings.NewReader(only)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if comments := cc.GetSectionComments(DEFAULT_SECTION); comments != "; defaults" {
+		t.Errorf("DEFAULT comments: expect '; defaults', got '%s'", comments)
+	}
+}
+
+func Test_FooterComments(t *testing.T) {
+	const conf = "[app]\nname = abc\n\n; footer note\n# last line\n"
+
+	c := newConfigFile(nil)
+	if err := c.read(strings.NewReader(conf)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if s := saveString(t, c); s != conf {
+		t.Errorf("SaveConfigFile: expect\n%s\ngot\n%s", conf, s)
+	}
+}
+
+func Test_ConfigWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := NewWriter(&buf)
+	cw.Comment("generated\n# do not edit")
+	cw.Key("global", "yes")
+	cw.Section("app")
+	cw.Key("name", " spaced ")
+	cw.Key("a=b", "c")
+
+	expect := "; generated\n# do not edit\nglobal = yes\n\n[app]\nname = ` spaced `\n`a=b` = c\n"
+	if s := strings.Replace(buf.String(), LineBreak, "\n", -1); s != expect {
+		t.Errorf("ConfigWriter: expect\n%s\ngot\n%s", expect, s)
+	}
+
+	c := newConfigFile(nil)
+	if err := c.read(&buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, _ := c.GetValue("app", "name"); v != " spaced " {
+		t.Errorf("app.name: expect ' spaced ', got '%s'", v)
+	}
+
+	if err := NewWriter(&buf).Key("", "v"); err == nil {
+		t.Error("Key: expect error for empty name")
+	}
+}